@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net/url"
+	"slices"
+)
+
+// apodSearchURL is an unofficial APOD archive with full-text search, which
+// the official API lacks
+const apodSearchURL = "https://apod.ellr.nl/api/search"
+
+// searchAPOD returns one page of APODs matching a full-text query
+func searchAPOD(query string, page int) ([]APOD, error) {
+	v := url.Values{}
+	v.Set("q", query)
+	v.Set("page", fmt.Sprint(page))
+	var results []APOD
+	if err := getJSON(apodSearchURL+"?"+v.Encode(), &results); err != nil {
+		return nil, fmt.Errorf("failed to search APOD archive: %w", err)
+	}
+	return results, nil
+}
+
+// fetchAPODSearch fetches and displays a random APOD matching a full-text
+// query
+func fetchAPODSearch(apiKey, query string, setWallpaper bool) error {
+	return fetchImage(func() (imageMeta, error) { return selectAPODSearch(apiKey, query) }, setWallpaper)
+}
+
+// selectAPODSearch picks a random image APOD from the first page of search
+// results; the entry itself is loaded from the official API by date, so it
+// is cached like any other APOD
+func selectAPODSearch(apiKey, query string) (imageMeta, error) {
+	results, err := searchAPOD(query, 1)
+	if err != nil {
+		return imageMeta{}, err
+	}
+	results = slices.DeleteFunc(results, func(apod APOD) bool {
+		return apod.Date == "" || (apod.MediaType != "" && apod.MediaType != "image" && !*allowVideo) || !titleAccepted(apod.Title)
+	})
+	if len(results) == 0 {
+		return imageMeta{}, fmt.Errorf("no APOD images found for query: %s", query)
+	}
+	apod, err := loadAPOD(apiKey, results[rand.Intn(len(results))].Date)
+	if err != nil {
+		return imageMeta{}, err
+	}
+	if !apodUsable(apod) {
+		return imageMeta{}, fmt.Errorf("APOD for %s is not an image (type: %s)", apod.Date, apod.MediaType)
+	}
+	return apodMeta(apod), nil
+}
@@ -1,51 +1,218 @@
 package main
 
 import (
+	"bufio"
+	"crypto/md5"
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"image"
 	"io"
 	"log"
 	"math/rand"
+	"net"
 	"net/http"
+	neturl "net/url"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/template"
 	"time"
 
 	"github.com/adrg/xdg"
+	"golang.org/x/sync/singleflight"
 )
 
 const (
 	defaultAPIKey = "DEMO_KEY"
+	nasaMaxHits   = 10000 // the search API pages no further than this
+	cacheSubdir   = "apodwall"
+)
+
+// API endpoints, variables so tests can point them at a local server
+var (
 	apodURL       = "https://api.nasa.gov/planetary/apod"
 	nasaImagesURL = "https://images-api.nasa.gov/search"
-	cacheSubdir   = "apodwall"
 )
 
+// secureHosts are hosts known to serve images over https, plain http URLs
+// pointing to these hosts get upgraded.
+var secureHosts = map[string]bool{
+	"apod.nasa.gov":          true,
+	"www.nasa.gov":           true,
+	"images.nasa.gov":        true,
+	"images-assets.nasa.gov": true,
+	"science.nasa.gov":       true,
+}
+
 var cacheDir string
 var httpClient *http.Client
 
+// fetchGroup coalesces concurrent identical fetches within the process
+var fetchGroup singleflight.Group
+
+var (
+	// errNotPublished is returned when the APOD API has no entry for a date yet.
+	errNotPublished = errors.New("APOD not published")
+	// errRateLimited is returned when an API responds with 429.
+	errRateLimited = errors.New("rate limit exceeded")
+	// errChecksumMismatch is returned when a download does not match its Content-MD5 header.
+	errChecksumMismatch = errors.New("checksum mismatch")
+	// errUndecodable is returned when a downloaded image fails to decode with -verify-decode.
+	errUndecodable = errors.New("image does not decode")
+	// errNotFound is returned when an API responds with 404.
+	errNotFound = errors.New("not found")
+	// errSkipImage is returned for images that cannot be used on this system, selectors pick another one.
+	errSkipImage = errors.New("unusable image")
+)
+
 var (
-	apodFlag      = flag.Bool("a", false, "Display APOD (Astronomy Picture of the Day) image URL")
-	nasaFlag      = flag.Bool("n", false, "Display random NASA image URL")
-	wallpaperFlag = flag.Bool("w", false, "Set the image as wallpaper (downloads and caches the image)")
-	query         = flag.String("q", "sun", "Search query for NASA images")
-	timeout       = flag.Duration("T", 30*time.Second, "HTTP request timeout")
-	apiKey        = flag.String("k", "", "NASA API key (overrides DATA_GOV_API_KEY environment variable)")
+	apodFlag       = flag.Bool("a", false, "Display APOD (Astronomy Picture of the Day) image URL")
+	nasaFlag       = flag.Bool("n", false, "Display random NASA image URL")
+	wallpaperFlag  = flag.Bool("w", false, "Set the image as wallpaper (downloads and caches the image)")
+	query          = flag.String("q", "sun", "Search query for NASA images")
+	timeout        = durationFlag("T", 30*time.Second, "HTTP request timeout, a `duration` like 30s, 0.5h or 1d")
+	apiKey         = flag.String("k", "", "NASA API key (overrides DATA_GOV_API_KEY environment variable)")
+	warmupDays     = flag.Int("warmup-days", 0, "Pre-fetch and cache the APODs of today and the last N days")
+	onWake         = flag.Bool("on-wake", false, "Keep running and set a new wallpaper on resume from suspend (Linux only)")
+	pruneVideos    = flag.Bool("prune-videos", false, "Delete cached images that came from video thumbnails")
+	postProcess    = flag.String("post-process-cmd", "", "Shell command to run on the image before setting it, {input} and {output} get replaced with paths")
+	benchRuns      = flag.Int("bench", 0, "Run N fetch cycles without setting the wallpaper and report latencies")
+	jplFlag        = flag.Bool("jpl", false, "Display random featured JPL image URL, or an APOD while the JPL endpoint is unavailable")
+	retries        = flag.Int("r", 3, "Maximum number of retries for failed downloads and API requests")
+	previewFirst   = flag.Bool("preview-first", false, "Open a low resolution preview and ask before downloading the full image")
+	dedup          = flag.Bool("dedup", false, "Replace cached images with identical content by hardlinks")
+	dedupSymlink   = flag.Bool("dedup-symlink", false, "Use symlinks instead of hardlinks when deduplicating, implies -dedup")
+	earthFlag      = flag.Bool("earth", false, "Display NASA Earth Observatory image of the day URL")
+	titleFilter    = flag.String("title-filter", "", "Skip images whose title matches this regular expression")
+	titleRequire   = flag.String("title-require", "", "Only use images whose title matches this regular expression")
+	preferBright   = flag.Bool("prefer-bright", false, "Only use bright images (downloads candidates to check)")
+	preferDark     = flag.Bool("prefer-dark", false, "Only use dark images (downloads candidates to check)")
+	downloadDir    = flag.String("download-dir", "", "Also save downloaded images to this directory")
+	outputTmpl     = flag.String("output-dir-template", "", "Path template below -download-dir, e.g. {{.Year}}/{{.Month}}/{{.Year}}-{{.Month}}-{{.Day}}-{{.Title}}")
+	spacexFlag     = flag.Bool("spacex", false, "Display random recent SpaceX Flickr photo URL")
+	singleFlight   = flag.Bool("single-flight", false, "Use lock files to avoid duplicate fetches by concurrent apodwall processes")
+	genreFlag      = flag.String("genre", "", "Only use APODs of these comma separated genres, e.g. nebula,galaxy")
+	changeIfOlder  = flag.Duration("change-if-older-than", 0, "Only change the wallpaper if it was set longer ago than this")
+	setGreeter     = flag.Bool("set-greeter", false, "Also set the login manager background (LightDM, SDDM; requires root)")
+	jsonFlag       = flag.Bool("json", false, "Print image metadata as compact JSON to stdout, with the cached path when downloaded by -w or -download-only")
+	jsonPretty     = flag.Bool("json-pretty", false, "Like -json, but indented for reading")
+	thumbs         = flag.Bool("thumbs", false, "Always use standard definition APOD images instead of HD, to save bandwidth")
+	verbose        = flag.Bool("v", false, "Verbose output")
+	daemon         = flag.Duration("daemon", 0, "Keep running and set a new wallpaper at this interval, e.g. 1h")
+	skipFullscreen = flag.Bool("skip-fullscreen", false, "In daemon mode, defer rotation while a fullscreen window is active (X11)")
+	minIdle        = flag.Duration("min-idle", 0, "In daemon mode, defer rotation unless the user has been idle at least this long (X11, needs xprintidle)")
+	rebuildIdx     = flag.Bool("rebuild-index", false, "Rebuild the cache index from the cached images and their metadata")
+	setCommand     = flag.String("set-command", "", "Command template used instead of the built-in wallpaper setters, e.g. 'swww img {{.Path}}'")
+	span           = flag.Bool("span", false, "Span a single image across all monitors, resizing it if necessary (X11, or Wayland with wlr-randr)")
+	keywords       = flag.String("keywords", "", "Comma separated NASA image keywords to filter by, e.g. 'solar system,planets'")
+	weightRecency  = flag.Float64("weight-recency", 0, "Selection weight favoring recent dates in random APOD picks, negative favors older ones")
+	weightNovelty  = flag.Float64("weight-novelty", 0, "Selection weight favoring dates in random APOD picks that were neither downloaded before nor are in the history")
+	connectTimeout = flag.Duration("connect-timeout", 0, "Timeout for establishing connections, e.g. 5s")
+	readTimeout    = flag.Duration("read-timeout", 0, "Timeout for a whole request including the body, overrides -T")
+	printCachePath = flag.String("print-cache-path", "", "Print the cache path an image URL would be stored at and exit")
+	imageListFile  = flag.String("image-list-file", "", "Display random image URL from a file with one URL per line")
+	configPath     = flag.String("config", "", "Config file with one flag per line, like: q = nebula (default $XDG_CONFIG_HOME/apodwall/config)")
+	watchConfig    = flag.Bool("watch-config", false, "In daemon mode, reload the config file when it changes")
+	legacySurvey   = flag.Bool("legacysurvey", false, "Display DESI Legacy Surveys cutout URL of a random Milky Way region")
+	galacticLat    = flag.Float64("galactic-latitude-range", 10, "Maximum galactic latitude in degrees for -legacysurvey")
+	apodSearch     = flag.String("apod-search", "", "Display random APOD matching this full-text query (unofficial archive search)")
+	qrLink         = flag.Bool("qr-link", false, "Add a QR code linking to the APOD page to a corner of the wallpaper")
+	qrSize         = flag.Int("qr-size", 160, "Size of the -qr-link QR code in pixels")
+	qrPosition     = flag.String("qr-position", "bottom-right", "Corner for the -qr-link QR code: top-left, top-right, bottom-left or bottom-right")
+	downloadOnly   = flag.Bool("download-only", false, "Download the image into the cache and print its path to stdout, without setting it; overrides -w")
+	setupWizard    = flag.Bool("setup", false, "Interactively check the desktop, store an API key and set a first wallpaper")
+	cacheFormat    = flag.String("cache-format", "", "Convert downloaded images to jpeg, png or webp (needs cwebp) before caching")
+	today          = flag.Bool("today", false, "Display today's APOD, or yesterday's if today's is not published yet")
+	yesterday      = flag.Bool("yesterday", false, "Display yesterday's APOD, which is always published")
+	timezone       = flag.String("timezone", "America/New_York", "Time zone that decides what today is, APOD is published on US Eastern time; Local for the system time zone")
+	validateCache  = flag.Bool("validate-cache-hit", false, "Check cached images against their recorded size, or decode them, and download again if truncated")
+	filterFlag     = flag.String("filter", "none", "Tint the wallpaper: none, grayscale, sepia or invert")
+	watchPath      = flag.String("watch-file", "", "Keep running and set a new wallpaper whenever this file is modified")
+	watchInterval  = flag.Duration("watch-interval", time.Second, "How often to check the -watch-file modification time")
+	fallbackSource = flag.String("fallback-source", "", "When rotating, use this source after repeated failures: apod, nasa[:query], jpl, earth, spacex, legacysurvey, github, epic, panstarrs or hubblesite")
+	fallbackAfter  = flag.Int("fallback-after", 3, "Number of consecutive failures before -fallback-source is used")
+	gnomeTrans     = flag.String("gnome-transition", "", "GNOME wallpaper transition as type[:seconds], e.g. fade:2")
+	hashBytes      = flag.Int("hash-bytes", 8, "Number of SHA-256 bytes used in cache file names, up to 32")
+	verifyDecode   = flag.Bool("verify-decode", false, "Fully decode downloaded images before caching them, downloading again if that fails")
+	maxVideoRetry  = flag.Int("max-retries-video", 10, "Maximum number of random APOD dates skipped for being videos")
+	nasaGitHub     = flag.Bool("nasa-github", false, "Display random image URL from a NASA GitHub repository (set GITHUB_TOKEN to avoid rate limits)")
+	i3lockImage    = flag.Bool("i3lock-image", false, "Also copy the wallpaper to $XDG_CONFIG_HOME/apodwall/lock.png for i3lock")
+	printI3lock    = flag.Bool("print-i3lock-config", false, "Print an i3 config fragment that locks the screen with the -i3lock-image and exit")
+	aspectMode     = flag.String("aspect-mode", "", "Scale the image to the primary monitor before setting it: crop, fit (average color borders) or letterbox (black borders); the size comes from xrandr or wlr-randr on Linux, and images are left as they are if it cannot be detected")
+	preferLandsc   = flag.Bool("prefer-landscape", false, "Only use images wider than tall (downloads candidates to check)")
+	preferPortrait = flag.Bool("prefer-portrait", false, "Only use images taller than wide (downloads candidates to check)")
+	pickRetries    = flag.Int("max-pick-retries", 10, "Maximum number of images skipped by -prefer-bright, -prefer-dark, -prefer-landscape and -prefer-portrait; dates fixed by -d, -today or -yesterday are not retried")
+	epicFlag       = flag.Bool("epic", false, "Display random recent DSCOVR EPIC image URL of the whole Earth")
+	sourceList     = flag.String("sources", "", "Comma separated sources to try in order until one works, e.g. apod,nasa:nebula,epic")
+	panstarrs      = flag.Bool("panstarrs", false, "Display Pan-STARRS cutout URL of a random Messier object")
+	apodRecent     = flag.Int("apod-recent", 0, "Only pick random APODs from the last N days")
+	macAllDesktops = flag.Bool("macos-all-desktops", true, "On macOS, set the wallpaper on every display and space, not only the current one")
+	macDesktop     = flag.Int("macos-desktop", 0, "On macOS, only set the wallpaper on the desktop with this index, starting at 1")
+	exportM3UPath  = flag.String("export-m3u", "", "Write a playlist of all cached images to this file, - for stdout, e.g. for mpv --playlist")
+	keyPool        = flag.String("key-pool", "", "Comma separated API keys to switch to for an hour when the primary key is rate limited")
+	curated        = flag.Bool("curated", false, "Display random APOD from a built-in list of exceptional images")
+	gnomeOptions   = flag.String("gnome-picture-options", "zoom", "GNOME wallpaper scaling: none, wallpaper, centered, scaled, stretched, zoom or spanned, which stretches one image across all monitors; -span implies spanned")
+	hubblesite     = flag.Bool("hubblesite", false, "Display image URL of a random HubbleSite news release")
+	compare        = flag.Bool("compare", false, "Pick two candidate images and ask which one to use, the first if stdin is not a terminal")
+	atSunrise      = flag.Bool("change-at-sunrise", false, "Keep running and set a new wallpaper at every sunrise at -lat and -lon")
+	atSunset       = flag.Bool("change-at-sunset", false, "Keep running and set a new wallpaper at every sunset at -lat and -lon")
+	latitude       = flag.Float64("lat", 0, "Latitude in degrees for -change-at-sunrise and -change-at-sunset")
+	longitude      = flag.Float64("lon", 0, "Longitude in degrees, east positive, for -change-at-sunrise and -change-at-sunset")
+	theme          = flag.String("theme", "", "Prefer APOD genres and NASA queries matching a dark or light desktop: dark, light or auto")
+	waitDisplay    = flag.Duration("wait-for-display", 0, "Retry setting the wallpaper until a display is available, for at most this long, e.g. 2m")
+	backendList    = flag.String("backends", "", "Comma separated Linux wallpaper setters to try in order: wallutils, wayland, sway, wbg, gnome, plasmatv, kde, xfce or feh (default depends on the session type)")
+	apodDay        = flag.String("d", "", "Display the APOD of this date, YYYY-MM-DD, implies -a")
+	showURLOnly    = flag.Bool("show-url-only", false, "Print only the image URL to stdout, without downloading or setting it; overrides -w")
+	nasaPage       = flag.Int("page", 0, "Page of NASA image search results to pick from, 0 for a random page")
+	nasaPageSize   = flag.Int("page-size", 100, "Number of NASA image search results per page, 1 to 100")
+	lastURL        = flag.Bool("last-url", false, "Print the original URL of the most recently downloaded image to stdout and exit")
+	cacheTTL       = flag.Duration("cache-ttl", 24*time.Hour, "How long cached NASA image search results are used without asking the server again")
+	historySize    = flag.Int("history-size", 30, "Number of recently shown APOD dates not picked again at random, 0 to disable")
+	clearHist      = flag.Bool("clear-history", false, "Forget the recently shown APOD dates and exit")
+	apodTitleSub   = flag.String("apod-title-contains", "", "Only use random APODs whose title contains this text, ignoring case, e.g. Orion")
+	healthCheck    = flag.Bool("health-check", false, "Check that the configured image sources are reachable, print a status table and exit")
+	kdeScreen      = flag.Int("kde-screen", -1, "On KDE Plasma, only set the wallpaper on the screen with this number, starting at 0; -1 for all screens")
+	allowVideo     = flag.Bool("allow-video", false, "Also use video APODs, by their thumbnail image; -prune-videos removes them from the cache again")
+	greeterImage   = flag.String("greeter-image", "", "Only set the login manager background to this image, without fetching anything or changing the desktop (LightDM, SDDM; requires root)")
+)
+
+// rateLimitWarn is the number of remaining API requests below which a
+// warning is printed
+const rateLimitWarn = 5
+
+// gnomePictureOptions are the values of the GNOME picture-options key
+var gnomePictureOptions = []string{"none", "wallpaper", "centered", "scaled", "stretched", "zoom", "spanned"}
+
+// maxRerolls limits how often a selection is repeated when filters reject it
+const maxRerolls = 10
+
+var (
+	titleFilterRe  *regexp.Regexp
+	titleRequireRe *regexp.Regexp
+	setCommandTmpl *template.Template
 )
 
 // APOD represents the Astronomy Picture of the Day
 type APOD struct {
-	Copyright   string `json:"copyright"`
-	Date        string `json:"date"`
-	Explanation string `json:"explanation"`
-	HDURL       string `json:"hdurl"`
-	MediaType   string `json:"media_type"`
-	Title       string `json:"title"`
-	URL         string `json:"url"`
+	Copyright    string `json:"copyright"`
+	Date         string `json:"date"`
+	Explanation  string `json:"explanation"`
+	HDURL        string `json:"hdurl"`
+	MediaType    string `json:"media_type"`
+	ThumbnailURL string `json:"thumbnail_url"`
+	Title        string `json:"title"`
+	URL          string `json:"url"`
 }
 
 // NASAImageResponse represents the response from NASA Image Library
@@ -54,46 +221,200 @@ type NASAImageResponse struct {
 		Metadata struct {
 			TotalHits int `json:"total_hits"`
 		} `json:"metadata"`
-		Items []struct {
-			Href string `json:"href"`
-			Data []struct {
-				NASAId      string `json:"nasa_id"`
-				Title       string `json:"title"`
-				Center      string `json:"center"`
-				Description string `json:"description"`
-				DateCreated string `json:"date_created"`
-			} `json:"data"`
-		} `json:"items"`
+		Items []NASAImageItem `json:"items"`
 	} `json:"collection"`
 }
 
+// NASAImageItem represents a single search result from NASA Image Library
+type NASAImageItem struct {
+	Href string `json:"href"`
+	Data []struct {
+		NASAId      string `json:"nasa_id"`
+		Title       string `json:"title"`
+		Center      string `json:"center"`
+		Description string `json:"description"`
+		DateCreated string `json:"date_created"`
+	} `json:"data"`
+}
+
 // NASAImageCollection represents the collection of image URLs
 type NASAImageCollection []string
 
+// imageMeta is stored in a sidecar file next to each cached image
+type imageMeta struct {
+	URL         string `json:"url"`
+	PreviewURL  string `json:"preview_url,omitempty"`
+	Title       string `json:"title,omitempty"`
+	Date        string `json:"date,omitempty"`
+	MediaType   string `json:"media_type,omitempty"`
+	NASAId      string `json:"nasa_id,omitempty"`
+	Size        int64  `json:"size,omitempty"`
+	Copyright   string `json:"copyright,omitempty"`
+	Explanation string `json:"explanation,omitempty"`
+	// FallbackURL is downloaded instead if URL fails, it is not stored
+	FallbackURL string `json:"-"`
+	// APODDate is set for images picked from APOD, for the history; it is
+	// not stored
+	APODDate string `json:"-"`
+}
+
 func main() {
 	flag.Parse()
-	httpClient = &http.Client{
-		Timeout: *timeout,
+	if useColor(os.Stderr) {
+		log.SetOutput(warningWriter{os.Stderr})
+	}
+	if err := loadConfig(configFile()); err != nil {
+		log.Fatal(err)
 	}
 	if err := initCacheDir(); err != nil {
 		log.Fatal("could not create cache dir")
 	}
-	key := *apiKey
-	if key == "" {
-		key = os.Getenv("DATA_GOV_API_KEY")
-	}
-	if key == "" {
-		key = defaultAPIKey
+	if err := setup(); err != nil {
+		log.Fatal(err)
 	}
+	key := resolveAPIKey()
 	switch {
-	case *apodFlag:
+	case *setupWizard:
+		if err := runSetupWizard(); err != nil {
+			printError("Error running setup: %v\n", err)
+			os.Exit(1)
+		}
+	case *printI3lock:
+		fmt.Print(i3lockConfig())
+	case *printCachePath != "":
+		fmt.Println(imageCachePath(normalizeImageURL(*printCachePath)))
+	case *greeterImage != "":
+		if err := setGreeterBackground(*greeterImage); err != nil {
+			printError("Error setting greeter background: %v\n", err)
+			os.Exit(1)
+		}
+	case *warmupDays > 0:
+		if err := warmupAPOD(key, *warmupDays); err != nil {
+			printError("Error warming up cache: %v\n", err)
+			os.Exit(1)
+		}
+	case *benchRuns > 0:
+		if err := runBench(key, *benchRuns); err != nil {
+			printError("Error running benchmark: %v\n", err)
+			os.Exit(1)
+		}
+	case *exportM3UPath != "":
+		if err := exportM3U(*exportM3UPath); err != nil {
+			printError("Error exporting playlist: %v\n", err)
+			os.Exit(1)
+		}
+	case *lastURL:
+		url, err := lastCachedURL()
+		if err != nil {
+			printError("Error finding last image: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(url)
+	case *healthCheck:
+		if err := runHealthCheck(key); err != nil {
+			printError("Error checking sources: %v\n", err)
+			os.Exit(1)
+		}
+	case *clearHist:
+		if err := clearHistory(); err != nil {
+			printError("Error clearing history: %v\n", err)
+			os.Exit(1)
+		}
+	case *rebuildIdx:
+		if err := rebuildIndex(); err != nil {
+			printError("Error rebuilding index: %v\n", err)
+			os.Exit(1)
+		}
+	case *pruneVideos:
+		if err := pruneVideoImages(); err != nil {
+			printError("Error pruning cache: %v\n", err)
+			os.Exit(1)
+		}
+	case *daemon > 0:
+		if err := runDaemon(key, *daemon); err != nil {
+			printError("Error running daemon: %v\n", err)
+			os.Exit(1)
+		}
+	case *onWake:
+		ignoreBrokenPipe()
+		if err := watchWake(func() error { return rotate(key, true) }); err != nil {
+			printError("Error watching for resume: %v\n", err)
+			os.Exit(1)
+		}
+	case *atSunrise || *atSunset:
+		if err := runSunDaemon(key); err != nil {
+			printError("Error running sun daemon: %v\n", err)
+			os.Exit(1)
+		}
+	case *watchPath != "":
+		ignoreBrokenPipe()
+		if err := watchFile(*watchPath, *watchInterval, func() error { return rotate(key, true) }); err != nil {
+			printError("Error watching file: %v\n", err)
+			os.Exit(1)
+		}
+	case *apodFlag || *today || *yesterday || *curated || *apodDay != "":
 		if err := fetchAPOD(key, *wallpaperFlag); err != nil {
-			fmt.Fprintf(os.Stderr, "Error fetching APOD: %v\n", err)
+			printError("Error fetching APOD: %v\n", err)
 			os.Exit(1)
 		}
 	case *nasaFlag:
-		if err := fetchNASAImage(*query, *wallpaperFlag); err != nil {
-			fmt.Fprintf(os.Stderr, "Error fetching NASA image: %v\n", err)
+		if err := fetchNASAImage(searchQuery(), *keywords, *nasaPage, *nasaPageSize, *wallpaperFlag); err != nil {
+			printError("Error fetching NASA image: %v\n", err)
+			os.Exit(1)
+		}
+	case *jplFlag:
+		if err := fetchJPL(key, *wallpaperFlag); err != nil {
+			printError("Error fetching JPL image: %v\n", err)
+			os.Exit(1)
+		}
+	case *earthFlag:
+		if err := fetchEarthObservatory(*wallpaperFlag); err != nil {
+			printError("Error fetching Earth Observatory image: %v\n", err)
+			os.Exit(1)
+		}
+	case *spacexFlag:
+		if err := fetchSpaceX(*wallpaperFlag); err != nil {
+			printError("Error fetching SpaceX photo: %v\n", err)
+			os.Exit(1)
+		}
+	case *sourceList != "":
+		if err := rotate(key, *wallpaperFlag); err != nil {
+			printError("Error fetching image: %v\n", err)
+			os.Exit(1)
+		}
+	case *hubblesite:
+		if err := fetchHubblesite(*wallpaperFlag); err != nil {
+			printError("Error fetching HubbleSite image: %v\n", err)
+			os.Exit(1)
+		}
+	case *panstarrs:
+		if err := fetchPanSTARRS(*wallpaperFlag); err != nil {
+			printError("Error fetching Pan-STARRS image: %v\n", err)
+			os.Exit(1)
+		}
+	case *epicFlag:
+		if err := fetchEPIC(key, *wallpaperFlag); err != nil {
+			printError("Error fetching EPIC image: %v\n", err)
+			os.Exit(1)
+		}
+	case *nasaGitHub:
+		if err := fetchNASAGitHub(*wallpaperFlag); err != nil {
+			printError("Error fetching NASA GitHub image: %v\n", err)
+			os.Exit(1)
+		}
+	case *apodSearch != "":
+		if err := fetchAPODSearch(key, *apodSearch, *wallpaperFlag); err != nil {
+			printError("Error searching APOD: %v\n", err)
+			os.Exit(1)
+		}
+	case *legacySurvey:
+		if err := fetchLegacySurvey(*wallpaperFlag); err != nil {
+			printError("Error fetching Legacy Surveys image: %v\n", err)
+			os.Exit(1)
+		}
+	case *imageListFile != "":
+		if err := fetchImageList(*imageListFile, *wallpaperFlag); err != nil {
+			printError("Error using image list: %v\n", err)
 			os.Exit(1)
 		}
 	default:
@@ -102,6 +423,343 @@ func main() {
 	}
 }
 
+// setup validates flags and prepares everything derived from them; it runs
+// again whenever the configuration is reloaded
+func setup() error {
+	httpClient = newHTTPClient()
+	if err := validateFlags(); err != nil {
+		return err
+	}
+	if err := compileTitleFilters(); err != nil {
+		return err
+	}
+	if err := compileOutputTemplate(); err != nil {
+		return err
+	}
+	if err := compileTimezone(); err != nil {
+		return err
+	}
+	if *apodDay != "" {
+		if _, err := parseAPODDate(*apodDay); err != nil {
+			return fmt.Errorf("-d: %w", err)
+		}
+	}
+	if err := compileSources(); err != nil {
+		return err
+	}
+	if err := compileTheme(); err != nil {
+		return err
+	}
+	if err := compileBackends(); err != nil {
+		return err
+	}
+	return compileSetCommand()
+}
+
+// resolveAPIKey returns the API key from -k, the environment, the key file
+// or the default
+func resolveAPIKey() string {
+	key := *apiKey
+	if key == "" {
+		key = os.Getenv("DATA_GOV_API_KEY")
+	}
+	if key == "" {
+		key = readKeyFile()
+	}
+	if key == "" {
+		key = defaultAPIKey
+	}
+	return key
+}
+
+// compileSetCommand parses the -set-command template
+func compileSetCommand() (err error) {
+	setCommandTmpl = nil
+	if *setCommand == "" {
+		return nil
+	}
+	if setCommandTmpl, err = template.New("set").Option("missingkey=error").Parse(*setCommand); err != nil {
+		return fmt.Errorf("invalid -set-command: %w", err)
+	}
+	return nil
+}
+
+// newHTTPClient returns a client honoring -T, -connect-timeout and
+// -read-timeout; the read timeout replaces -T for the whole request
+func newHTTPClient() *http.Client {
+	client := &http.Client{
+		Timeout: *timeout,
+	}
+	if *readTimeout > 0 {
+		client.Timeout = *readTimeout
+	}
+	if *connectTimeout == 0 && !longRunning() {
+		return client
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if *connectTimeout > 0 {
+		transport.DialContext = (&net.Dialer{
+			Timeout:   *connectTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext
+	}
+	if longRunning() {
+		// requests are far apart, do not keep connections around for long
+		transport.IdleConnTimeout = 90 * time.Second
+		transport.MaxIdleConns = 10
+	}
+	client.Transport = transport
+	return client
+}
+
+// longRunning reports whether apodwall keeps running to change the
+// wallpaper repeatedly
+func longRunning() bool {
+	return *daemon > 0 || *onWake || *watchPath != "" || *atSunrise || *atSunset
+}
+
+// verbosef logs a message to stderr if -v is set
+func verbosef(format string, args ...any) {
+	if *verbose {
+		log.Printf(format, args...)
+	}
+}
+
+// selectImage picks an image from the selected source, APOD if no other
+// source was requested
+func selectImage(apiKey string) (imageMeta, error) {
+	if len(sourceOrder) > 0 {
+		return selectFromSources(apiKey)
+	}
+	if *hubblesite {
+		return selectHubblesite()
+	}
+	if *panstarrs {
+		return selectPanSTARRS()
+	}
+	if *epicFlag {
+		return selectEPIC(apiKey)
+	}
+	if *nasaGitHub {
+		return selectNASAGitHub()
+	}
+	if *apodSearch != "" {
+		return selectAPODSearch(apiKey, *apodSearch)
+	}
+	if *legacySurvey {
+		return selectLegacySurvey()
+	}
+	if *imageListFile != "" {
+		return selectImageList(*imageListFile)
+	}
+	if *spacexFlag {
+		meta, err := selectSpaceX()
+		if err != nil {
+			return meta, fmt.Errorf("failed to fetch SpaceX photo: %w", err)
+		}
+		return meta, nil
+	}
+	if *earthFlag {
+		meta, err := selectEarthObservatory()
+		if err != nil {
+			return meta, fmt.Errorf("failed to fetch Earth Observatory image: %w", err)
+		}
+		return meta, nil
+	}
+	if *jplFlag {
+		meta, err := selectJPLOrAPOD(apiKey)
+		if err != nil {
+			return meta, fmt.Errorf("failed to fetch JPL image: %w", err)
+		}
+		return meta, nil
+	}
+	if *nasaFlag {
+		meta, err := selectNASAImage(searchQuery(), *keywords, *nasaPage, *nasaPageSize)
+		if err != nil {
+			return meta, fmt.Errorf("failed to fetch NASA image: %w", err)
+		}
+		return meta, nil
+	}
+	meta, err := selectAPOD(apiKey)
+	if err != nil {
+		return meta, fmt.Errorf("failed to fetch APOD: %w", err)
+	}
+	return meta, nil
+}
+
+// ignoreBrokenPipe keeps long running modes alive when the process reading
+// their output goes away; writes then fail with EPIPE instead of killing us
+func ignoreBrokenPipe() {
+	signal.Ignore(syscall.SIGPIPE)
+}
+
+// watchWake listens for the logind PrepareForSleep signal on the system bus
+// and calls f each time the system resumes; blocks until the monitor exits
+func watchWake(f func() error) error {
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("resume detection is only supported on linux")
+	}
+	cmd := exec.Command("dbus-monitor", "--system",
+		"type='signal',interface='org.freedesktop.login1.Manager',member='PrepareForSleep'")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start dbus-monitor: %w", err)
+	}
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		// PrepareForSleep carries true before suspend and false on resume
+		if strings.TrimSpace(scanner.Text()) != "boolean false" {
+			continue
+		}
+		if err := f(); err != nil {
+			log.Printf("rotation on resume failed: %v", err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return cmd.Wait()
+}
+
+// validateFlags rejects contradictory flag combinations before any network
+// request is made
+func validateFlags() error {
+	var sources, modes []string
+	for name, set := range map[string]bool{
+		"-a":               *apodFlag,
+		"-n":               *nasaFlag,
+		"-jpl":             *jplFlag,
+		"-earth":           *earthFlag,
+		"-spacex":          *spacexFlag,
+		"-image-list-file": *imageListFile != "",
+		"-legacysurvey":    *legacySurvey,
+		"-apod-search":     *apodSearch != "",
+		"-nasa-github":     *nasaGitHub,
+		"-epic":            *epicFlag,
+		"-panstarrs":       *panstarrs,
+		"-hubblesite":      *hubblesite,
+		"-sources":         *sourceList != "",
+	} {
+		if set {
+			sources = append(sources, name)
+		}
+	}
+	for name, set := range map[string]bool{
+		"-warmup-days":         *warmupDays > 0,
+		"-bench":               *benchRuns > 0,
+		"-prune-videos":        *pruneVideos,
+		"-rebuild-index":       *rebuildIdx,
+		"-export-m3u":          *exportM3UPath != "",
+		"-last-url":            *lastURL,
+		"-clear-history":       *clearHist,
+		"-health-check":        *healthCheck,
+		"-print-cache-path":    *printCachePath != "",
+		"-setup":               *setupWizard,
+		"-print-i3lock-config": *printI3lock,
+		"-daemon":              *daemon > 0,
+		"-on-wake":             *onWake,
+		"-watch-file":          *watchPath != "",
+		"-change-at-sunrise":   *atSunrise || *atSunset,
+	} {
+		if set {
+			modes = append(modes, name)
+		}
+	}
+	slices.Sort(sources)
+	slices.Sort(modes)
+	switch {
+	case len(sources) > 1:
+		return fmt.Errorf("only one image source may be selected, got %s", strings.Join(sources, ", "))
+	case len(modes) > 1:
+		return fmt.Errorf("%s are mutually exclusive", strings.Join(modes, ", "))
+	case *preferBright && *preferDark:
+		return fmt.Errorf("-prefer-bright and -prefer-dark are mutually exclusive")
+	case *preferLandsc && *preferPortrait:
+		return fmt.Errorf("-prefer-landscape and -prefer-portrait are mutually exclusive")
+	case *today && *yesterday, *curated && (*today || *yesterday), *apodDay != "" && (*today || *yesterday || *curated):
+		return fmt.Errorf("-d, -today, -yesterday and -curated are mutually exclusive")
+	case (*today || *yesterday || *curated || *apodDay != "") && len(sources) == 1 && sources[0] != "-a":
+		return fmt.Errorf("-d, -today, -yesterday and -curated only apply to APOD, not %s", sources[0])
+	case (*genreFlag != "" || *apodTitleSub != "") && len(sources) == 1 && sources[0] != "-a":
+		return fmt.Errorf("-genre and -apod-title-contains only apply to APOD, not %s", sources[0])
+	case *outputTmpl != "" && *downloadDir == "":
+		return fmt.Errorf("-output-dir-template requires -download-dir")
+	case *warmupDays < 0 || *benchRuns < 0 || *retries < 0 || *maxVideoRetry < 0 || *pickRetries < 0 || *apodRecent < 0 || *historySize < 0:
+		return fmt.Errorf("-warmup-days, -bench, -r, -max-retries-video, -max-pick-retries, -apod-recent and -history-size must not be negative")
+	case (*skipFullscreen || *minIdle > 0) && *daemon == 0:
+		return fmt.Errorf("-skip-fullscreen and -min-idle require -daemon")
+	case *cacheFormat != "" && cacheFormatExt[*cacheFormat] == "":
+		return fmt.Errorf("-cache-format must be jpeg, png or webp")
+	case *filterFlag != "none" && imageFilters[*filterFlag] == nil:
+		return fmt.Errorf("-filter must be none, grayscale, sepia or invert")
+	case *gnomeTrans != "" && !validTransition(*gnomeTrans):
+		return fmt.Errorf("-gnome-transition must be type[:seconds], e.g. fade:2")
+	case *aspectMode != "" && !slices.Contains(aspectModes, *aspectMode):
+		return fmt.Errorf("-aspect-mode must be crop, fit or letterbox")
+	case *aspectMode != "" && *span:
+		return fmt.Errorf("-aspect-mode and -span are mutually exclusive")
+	case !slices.Contains(gnomePictureOptions, *gnomeOptions):
+		return fmt.Errorf("-gnome-picture-options must be one of %s", strings.Join(gnomePictureOptions, ", "))
+	case *showURLOnly && (*jsonFlag || *jsonPretty || *downloadOnly):
+		return fmt.Errorf("-show-url-only cannot be combined with -json, -json-pretty or -download-only")
+	case *nasaPage < 0:
+		return fmt.Errorf("-page must not be negative")
+	case *nasaPageSize < 1 || *nasaPageSize > 100:
+		return fmt.Errorf("-page-size must be between 1 and 100")
+	case !slices.Contains(qrPositions, *qrPosition):
+		return fmt.Errorf("-qr-position must be one of %s", strings.Join(qrPositions, ", "))
+	case *kdeScreen < -1:
+		return fmt.Errorf("-kde-screen must be a screen number, or -1 for all screens")
+	case *macDesktop < 0:
+		return fmt.Errorf("-macos-desktop must not be negative")
+	case *hashBytes < 1 || *hashBytes > 32:
+		return fmt.Errorf("-hash-bytes must be between 1 and 32")
+	case *fallbackAfter < 1:
+		return fmt.Errorf("-fallback-after must be at least 1")
+	case *fallbackSource != "" && !slices.Contains(sourceNames, strings.SplitN(*fallbackSource, ":", 2)[0]):
+		return fmt.Errorf("unknown -fallback-source %q, want one of %s", *fallbackSource, strings.Join(sourceNames, ", "))
+	case *qrLink && *qrSize <= 0:
+		return fmt.Errorf("-qr-size must be positive")
+	case *watchConfig && *daemon == 0:
+		return fmt.Errorf("-watch-config requires -daemon")
+	}
+	return nil
+}
+
+// validTransition reports whether a -gnome-transition value is a type
+// optionally followed by a non-negative number of seconds
+func validTransition(value string) bool {
+	kind, seconds, ok := strings.Cut(value, ":")
+	if kind == "" {
+		return false
+	}
+	if !ok {
+		return true
+	}
+	d, err := strconv.ParseFloat(seconds, 64)
+	return err == nil && d >= 0
+}
+
+// compileTitleFilters compiles the -title-filter and -title-require
+// expressions
+func compileTitleFilters() (err error) {
+	titleFilterRe, titleRequireRe = nil, nil
+	if *titleFilter != "" {
+		if titleFilterRe, err = regexp.Compile(*titleFilter); err != nil {
+			return fmt.Errorf("invalid -title-filter: %w", err)
+		}
+	}
+	if *titleRequire != "" {
+		if titleRequireRe, err = regexp.Compile(*titleRequire); err != nil {
+			return fmt.Errorf("invalid -title-require: %w", err)
+		}
+	}
+	return nil
+}
+
 // initCacheDir initializes the cache directory using XDG spec
 func initCacheDir() error {
 	cacheDir = filepath.Join(xdg.CacheHome, cacheSubdir)
@@ -113,56 +771,584 @@ func initCacheDir() error {
 
 // fetchAPOD fetches and displays a random APOD image URL
 func fetchAPOD(apiKey string, setWallpaper bool) error {
+	return fetchImage(func() (imageMeta, error) { return selectAPOD(apiKey) }, setWallpaper)
+}
+
+// selectAPOD picks a random APOD, or today's or yesterday's with -today and
+// -yesterday, or a curated one, and returns its image metadata; random dates are rerolled when
+// they are videos, in the recent history or rejected by the title or genre filters
+func selectAPOD(apiKey string) (imageMeta, error) {
+	if *apodDay != "" {
+		return selectAPODDate(apiKey, *apodDay)
+	}
+	if *today {
+		return selectAPODDay(apiKey, apodNow())
+	}
+	if *yesterday {
+		return selectAPODDay(apiKey, apodNow().AddDate(0, 0, -1))
+	}
+	if *curated {
+		return selectCuratedAPOD(apiKey)
+	}
+	var (
+		videos, filtered, repeats int
+		history                   = loadHistory()
+	)
+	for {
+		dateStr := randomAPODDate()
+		if slices.Contains(history, dateStr) && repeats < maxRerolls {
+			repeats++
+			verbosef("skipping %s: shown recently", dateStr)
+			continue
+		}
+		apod, err := loadAPOD(apiKey, dateStr)
+		if err != nil {
+			return imageMeta{}, err
+		}
+		if !apodUsable(apod) {
+			if videos++; videos > *maxVideoRetry {
+				return imageMeta{}, fmt.Errorf("no image APOD found, skipped %d non-image dates (-max-retries-video)", videos)
+			}
+			verbosef("skipping %s: not an image (type: %s)", dateStr, apod.MediaType)
+			continue
+		}
+		if titleAccepted(apod.Title) && apodTitleContains(apod.Title) && genreAccepted(apod) {
+			return apodMeta(apod), nil
+		}
+		if filtered >= maxRerolls {
+			return imageMeta{}, fmt.Errorf("no APOD matching filters after %d attempts", filtered+1)
+		}
+		filtered++
+		verbosef("skipping %s: %q filtered", dateStr, apod.Title)
+	}
+}
+
+// randomAPODDate returns a random date between the first APOD, or the start
+// of the -apod-recent window, and today, both included
+func randomAPODDate() string {
+	return randomAPODDateAt(apodNow())
+}
+
+// randomAPODDateAt is randomAPODDate with today at now
+func randomAPODDateAt(now time.Time) string {
+	var (
+		startDate = apodFirstDay
+		endDate   = now
+	)
+	if *apodRecent > 0 {
+		if recent := endDate.AddDate(0, 0, -*apodRecent); recent.After(startDate) {
+			startDate = recent
+		}
+	}
+	if weightedPolicy() {
+		return weightedDate(startDate, endDate).Format("2006-01-02")
+	}
 	var (
-		startDate  = time.Date(1995, 6, 16, 0, 0, 0, 0, time.UTC)
-		endDate    = time.Now()
-		daysDiff   = int(endDate.Sub(startDate).Hours() / 24)
-		randomDays = rand.Intn(daysDiff)
+		randomDays = rand.Intn(calendarDays(startDate, endDate) + 1)
 		randomDate = startDate.AddDate(0, 0, randomDays)
-		dateStr    = randomDate.Format("2006-01-02")
-		url        = fmt.Sprintf("%s?api_key=%s&date=%s", apodURL, apiKey, dateStr)
-		cacheKey   = fmt.Sprintf("apod_%s.json", dateStr)
-		cachePath  = filepath.Join(cacheDir, cacheKey)
-		apod       APOD
+	)
+	return randomDate.Format("2006-01-02")
+}
+
+// titleAccepted reports whether a title passes -title-filter and
+// -title-require
+func titleAccepted(title string) bool {
+	if titleFilterRe != nil && titleFilterRe.MatchString(title) {
+		return false
+	}
+	if titleRequireRe != nil && !titleRequireRe.MatchString(title) {
+		return false
+	}
+	return true
+}
+
+// apodTitleContains reports whether an APOD title contains the
+// -apod-title-contains text, ignoring case
+func apodTitleContains(title string) bool {
+	return strings.Contains(strings.ToLower(title), strings.ToLower(*apodTitleSub))
+}
+
+// fetchImage picks an image using pick, rerolling while it does not match
+// the preferred brightness, then displays it; APOD dates are recorded in
+// the history once shown
+func fetchImage(pick func() (imageMeta, error), setWallpaper bool) error {
+	if *downloadOnly || *showURLOnly {
+		setWallpaper = false
+	}
+	if setWallpaper && *changeIfOlder > 0 {
+		if st, err := loadState(); err == nil && time.Since(st.SetAt) < *changeIfOlder {
+			fmt.Fprintf(os.Stderr, "wallpaper was set %s ago, not changing\n", time.Since(st.SetAt).Round(time.Second))
+			return nil
+		}
+	}
+	meta, err := pickImage(pick)
+	if err != nil {
+		return err
+	}
+	if *compare {
+		other, err := pickImage(pick)
+		if err != nil {
+			log.Printf("warning: failed to pick a second image: %v\n", err)
+		} else if meta, err = chooseImage(meta, other); err != nil {
+			return err
+		}
+	}
+	if err := showImage(meta, setWallpaper); err != nil {
+		return err
+	}
+	if meta.APODDate != "" {
+		recordHistory(meta.APODDate)
+	}
+	return nil
+}
+
+// chooseImage lists two candidate images and asks which one to use; without
+// a terminal, the first one is used
+func chooseImage(first, second imageMeta) (imageMeta, error) {
+	if !isTerminal(os.Stdin) || first.URL == second.URL {
+		return first, nil
+	}
+	for i, meta := range []imageMeta{first, second} {
+		fmt.Fprintf(os.Stderr, "%d) %s\n   %s\n", i+1, meta.Title, meta.URL)
+	}
+	r := bufio.NewReader(os.Stdin)
+	for {
+		fmt.Fprint(os.Stderr, "Use image [1/2]: ")
+		answer, err := r.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return first, err
+		}
+		switch strings.TrimSpace(answer) {
+		case "1":
+			return first, nil
+		case "2":
+			return second, nil
+		}
+		if err == io.EOF {
+			return first, nil
+		}
+	}
+}
+
+// pickImage calls pick until an image matches the preferred brightness and
+// orientation, if any, and can be used on this system; checking them
+// requires downloading the image, except for HEIC URLs that cannot be
+// converted and images rejected before in this run; a fixed APOD date is not
+// picked again
+func pickImage(pick func() (imageMeta, error)) (imageMeta, error) {
+	var (
+		want     = preferredBrightness()
+		orient   = preferredOrientation()
+		rejected = make(map[string]string)
+	)
+	for attempt := 0; ; attempt++ {
+		meta, err := pick()
+		unusable := heicURL(meta.URL) && !heicConvertible()
+		if err != nil || (want == "" && orient == "" && (*showURLOnly || !unusable)) {
+			return meta, err
+		}
+		reason, ok := rejected[meta.URL]
+		switch {
+		case ok:
+			// rejected earlier in this run, do not download it again
+		case unusable:
+			reason = "HEIC images can only be converted on macOS"
+		default:
+			switch imagePath, err := downloadAndCacheImage(meta); {
+			case errors.Is(err, errSkipImage):
+				reason = err.Error()
+			case err != nil:
+				return meta, fmt.Errorf("failed to download image: %w", err)
+			default:
+				reason, _ = imageRejected(imagePath, want, orient)
+			}
+		}
+		if reason == "" {
+			return meta, nil
+		}
+		rejected[meta.URL] = reason
+		if fixedAPODDate() {
+			// picking again would only return the same image
+			return meta, fmt.Errorf("the APOD for the date given by -d, -today or -yesterday does not match: %s", reason)
+		}
+		if attempt >= *pickRetries {
+			return meta, fmt.Errorf("no matching image found after %d attempts", attempt+1)
+		}
+		fmt.Fprintf(os.Stderr, "skipping %s: %s\n", meta.URL, reason)
+	}
+}
+
+// imageRejected checks a downloaded image against the preferred brightness
+// and orientation and returns why it does not match; images that cannot be
+// classified are accepted
+func imageRejected(imagePath, want, orient string) (string, bool) {
+	if orient != "" {
+		o, err := imageOrientation(imagePath)
+		if err != nil {
+			log.Printf("warning: failed to get orientation of %s: %v\n", imagePath, err)
+		} else if o != orient && o != "square" {
+			return o, true
+		}
+	}
+	if want != "" {
+		class, err := classifyImageBrightness(imagePath)
+		if err != nil {
+			log.Printf("warning: failed to classify %s: %v\n", imagePath, err)
+		} else if class != want {
+			return class, true
+		}
+	}
+	return "", false
+}
+
+// imageRecord is printed with -json: the image metadata along with the path
+// of the cached image, if it was downloaded
+type imageRecord struct {
+	imageMeta
+	Path string `json:"path,omitempty"`
+}
+
+// showImage prints the image URL and optionally sets it as wallpaper, or
+// with -download-only, downloads it and prints the cached path; with -json,
+// the metadata is printed to stdout after that instead
+func showImage(meta imageMeta, setWallpaper bool) error {
+	if *showURLOnly {
+		fmt.Println(meta.URL)
+		return nil
+	}
+	jsonOutput := *jsonFlag || *jsonPretty
+	if !jsonOutput {
+		fmt.Fprintln(os.Stderr, colorize(os.Stderr, colorCyan, meta.URL))
+	}
+	var (
+		imagePath string
+		err       error
+	)
+	switch {
+	case *downloadOnly:
+		if imagePath, err = downloadAndCacheImage(meta); err != nil {
+			return fmt.Errorf("failed to download image: %w", err)
+		}
+		if !jsonOutput {
+			fmt.Println(imagePath)
+		}
+	case setWallpaper:
+		if imagePath, err = applyWallpaper(meta); err != nil {
+			return err
+		}
+	}
+	if jsonOutput {
+		return writeJSON(os.Stdout, imageRecord{meta, imagePath}, *jsonPretty)
+	}
+	return nil
+}
+
+// writeJSON writes v as a single line of JSON, or indented if pretty is set
+func writeJSON(w io.Writer, v any, pretty bool) error {
+	var (
+		b   []byte
+		err error
+	)
+	if pretty {
+		b, err = json.MarshalIndent(v, "", "  ")
+	} else {
+		b, err = json.Marshal(v)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
+
+// apodMeta returns the image metadata for an APOD
+func apodMeta(apod APOD) imageMeta {
+	meta := imageMeta{
+		URL:       apodImageURL(apod),
+		Title:     apod.Title,
+		Date:      apod.Date,
+		APODDate:  apod.Date,
+		MediaType: apod.MediaType,
+		// copyright lines come with surrounding newlines
+		Copyright:   strings.TrimSpace(apod.Copyright),
+		Explanation: apod.Explanation,
+	}
+	if preview := normalizeImageURL(apod.URL); apod.MediaType == "image" && preview != meta.URL {
+		meta.PreviewURL = preview
+		meta.FallbackURL = preview
+	}
+	return meta
+}
+
+// applyWallpaper downloads an image and sets it as wallpaper; returns the
+// path of the cached image, empty if the preview was declined
+func applyWallpaper(meta imageMeta) (string, error) {
+	if *previewFirst && meta.PreviewURL != "" && isTerminal(os.Stdin) {
+		ok, err := previewImage(meta)
+		if err != nil {
+			return "", fmt.Errorf("failed to preview image: %w", err)
+		}
+		if !ok {
+			return "", nil
+		}
+	}
+	imagePath, err := downloadAndCacheImage(meta)
+	if err != nil {
+		return "", fmt.Errorf("failed to download image: %w", err)
+	}
+	cachePath := imagePath
+	if *downloadDir != "" {
+		archivePath, err := archiveImage(imagePath, meta)
+		if err != nil {
+			return "", fmt.Errorf("failed to save image: %w", err)
+		}
+		fmt.Fprintln(os.Stderr, archivePath)
+	}
+	if *postProcess != "" {
+		if imagePath, err = postProcessImage(imagePath, *postProcess); err != nil {
+			return "", fmt.Errorf("failed to post-process image: %w", err)
+		}
+	}
+	if *filterFlag != "none" {
+		if imagePath, err = filterImage(imagePath, *filterFlag); err != nil {
+			return "", fmt.Errorf("failed to filter image: %w", err)
+		}
+	}
+	if *aspectMode != "" {
+		if imagePath, err = aspectImage(imagePath, *aspectMode); err != nil {
+			return "", fmt.Errorf("failed to adjust aspect ratio: %w", err)
+		}
+	}
+	if *span {
+		if imagePath, err = spanImage(imagePath); err != nil {
+			return "", fmt.Errorf("failed to span image: %w", err)
+		}
+	}
+	if *qrLink {
+		if imagePath, err = annotateQR(imagePath, meta); err != nil {
+			return "", fmt.Errorf("failed to add QR code: %w", err)
+		}
+	}
+	setWallpaper := func() error {
+		if setCommandTmpl != nil {
+			return runSetCommand(imagePath, meta)
+		}
+		return setWallpaperImage(imagePath)
+	}
+	if *waitDisplay > 0 {
+		err = waitForDisplay(*waitDisplay, setWallpaper)
+	} else {
+		err = setWallpaper()
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to set wallpaper: %w", err)
+	}
+	applyGreeter(imagePath)
+	applyLockImage(imagePath)
+	st := wallpaperState{
+		Path:  imagePath,
+		URL:   meta.URL,
+		Title: meta.Title,
+		SetAt: time.Now(),
+	}
+	if err := saveState(st); err != nil {
+		log.Printf("warning: failed to save state: %v\n", err)
+	}
+	return cachePath, nil
+}
+
+// previewImage downloads and opens the preview of an image and asks whether
+// to continue with the full image
+func previewImage(meta imageMeta) (bool, error) {
+	preview := meta
+	preview.URL, preview.PreviewURL = meta.PreviewURL, ""
+	previewPath, err := downloadAndCacheImage(preview)
+	if err != nil {
+		return false, err
+	}
+	if err := openFile(previewPath); err != nil {
+		log.Printf("warning: failed to open preview: %v\n", err)
+	}
+	fmt.Fprintf(os.Stderr, "%s\nDownload full image and set as wallpaper? [Y/n] ", previewPath)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "", "y", "yes":
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+// openFile opens a file with the default application
+func openFile(path string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", path)
+	case "windows":
+		cmd = exec.Command("cmd", "/c", "start", "", path)
+	default:
+		cmd = exec.Command("xdg-open", path)
+	}
+	return cmd.Start()
+}
+
+// isTerminal reports whether f is connected to a terminal
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// loadAPOD returns the APOD for a given date, from cache if possible;
+// concurrent calls for the same date share a single fetch
+func loadAPOD(apiKey, dateStr string) (APOD, error) {
+	v, err, _ := fetchGroup.Do("apod:"+dateStr, func() (any, error) {
+		return loadAPODOnce(apiKey, dateStr)
+	})
+	return v.(APOD), err
+}
+
+// loadAPODOnce returns the APOD for a given date, from cache if possible;
+// with -single-flight, the fetch is guarded by a lock file so concurrent
+// processes do not fetch the same date twice; rate limited requests are
+// repeated with the next -key-pool key
+func loadAPODOnce(apiKey, dateStr string) (APOD, error) {
+	var (
+		cacheKey  = fmt.Sprintf("apod_%s.json", dateStr)
+		cachePath = filepath.Join(cacheDir, cacheKey)
+		apod      APOD
 	)
 	if cachedData, err := os.ReadFile(cachePath); err == nil {
-		if err := json.Unmarshal(cachedData, &apod); err != nil {
-			if err := fetchAndCacheAPOD(url, cachePath, &apod); err != nil {
-				return err
+		if err := json.Unmarshal(cachedData, &apod); err == nil {
+			bench.observeCache(true)
+			return apod, nil
+		}
+	}
+	if *singleFlight {
+		release, err := acquireLock(cachePath, *timeout)
+		if err != nil {
+			return apod, err
+		}
+		defer release()
+		// another process may have fetched the date while we waited
+		if cachedData, err := os.ReadFile(cachePath); err == nil {
+			if err := json.Unmarshal(cachedData, &apod); err == nil {
+				bench.observeCache(true)
+				return apod, nil
+			}
+		}
+	}
+	bench.observeCache(false)
+	for {
+		key := activeKey(apiKey)
+		err := fetchAndCacheAPOD(apodRequestURL(key, dateStr), cachePath, &apod)
+		if errors.Is(err, errRateLimited) && activeKey(apiKey) != key {
+			continue
+		}
+		return apod, err
+	}
+}
+
+// apodRequestURL returns the APOD API URL for a date; hd=true is deprecated
+// and ignored by the API, but still honored by some mirrors and proxies
+func apodRequestURL(apiKey, dateStr string) string {
+	v := neturl.Values{}
+	v.Set("api_key", apiKey)
+	v.Set("date", dateStr)
+	v.Set("hd", "true")
+	v.Set("thumbs", "true")
+	return apodURL + "?" + v.Encode()
+}
+
+// warmupAPOD pre-fetches APOD metadata and images for today and the last
+// days, so later random picks of these dates can be served from the cache;
+// future APODs do not exist yet
+func warmupAPOD(apiKey string, days int) error {
+	now := apodNow()
+	for i := 0; i <= days; i++ {
+		dateStr := now.AddDate(0, 0, -i).Format("2006-01-02")
+		apod, err := loadAPOD(apiKey, dateStr)
+		if err != nil {
+			if errors.Is(err, errNotPublished) || i > 0 {
+				fmt.Fprintf(os.Stderr, "skipping %s: %v\n", dateStr, err)
+				continue
 			}
-		}
-	} else {
-		if err := fetchAndCacheAPOD(url, cachePath, &apod); err != nil {
 			return err
 		}
+		if !apodUsable(apod) {
+			verbosef("skipping %s: not an image (type: %s)", dateStr, apod.MediaType)
+			continue
+		}
+		imagePath, err := downloadAndCacheImage(apodMeta(apod))
+		if err != nil {
+			return fmt.Errorf("failed to download image for %s: %w", dateStr, err)
+		}
+		fmt.Fprintln(os.Stderr, imagePath)
 	}
-	if apod.MediaType != "image" {
-		return fmt.Errorf("APOD for %s is not an image (type: %s)", dateStr, apod.MediaType)
-	}
+	return nil
+}
+
+// apodUsable reports whether an APOD can be used as wallpaper: an image or,
+// with -allow-video, a video with a thumbnail
+func apodUsable(apod APOD) bool {
+	return apod.MediaType == "image" || (*allowVideo && apod.ThumbnailURL != "")
+}
+
+// apodImageURL returns the normalized image URL of an APOD, preferring HD
+// unless -thumbs is set; for videos, this is the thumbnail
+func apodImageURL(apod APOD) string {
 	imageURL := apod.URL
-	if apod.HDURL != "" {
+	switch {
+	case apod.MediaType == "video" && apod.ThumbnailURL != "":
+		imageURL = apod.ThumbnailURL
+	case *thumbs && apod.URL != "":
+		verbosef("using standard definition image for %s (-thumbs)", apod.Date)
+	case apod.HDURL != "":
 		imageURL = apod.HDURL
 	}
-	fmt.Fprintln(os.Stderr, imageURL)
-	if setWallpaper {
-		imagePath, err := downloadAndCacheImage(imageURL)
-		if err != nil {
-			return fmt.Errorf("failed to download image: %w", err)
-		}
-		if err := setWallpaperImage(imagePath); err != nil {
-			return fmt.Errorf("failed to set wallpaper: %w", err)
-		}
+	return normalizeImageURL(imageURL)
+}
+
+// normalizeImageURL resolves protocol-relative URLs and upgrades plain http
+// to https for known hosts
+func normalizeImageURL(imageURL string) string {
+	if strings.HasPrefix(imageURL, "//") {
+		return "https:" + imageURL
 	}
-	return nil
+	u, err := neturl.Parse(imageURL)
+	if err != nil {
+		return imageURL
+	}
+	if u.Scheme == "http" && secureHosts[strings.ToLower(u.Hostname())] {
+		u.Scheme = "https"
+		return u.String()
+	}
+	return imageURL
 }
 
 // fetchAndCacheAPOD fetches APOD data and caches it
 func fetchAndCacheAPOD(url, cachePath string, apod *APOD) error {
-	resp, err := httpClient.Get(url)
+	start := time.Now()
+	resp, err := getWithRetry(url)
+	bench.observeAPI(start)
 	if err != nil {
 		return fmt.Errorf("failed to fetch APOD: %w", err)
 	}
 	defer resp.Body.Close()
+	warnRateLimit(resp.Header)
+	observeRateLimit(url, resp)
+	if resp.StatusCode == http.StatusNotFound {
+		return errNotPublished
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return errRateLimited
+	}
 	if resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("API returned status %d", resp.StatusCode)
 	}
@@ -170,8 +1356,8 @@ func fetchAndCacheAPOD(url, cachePath string, apod *APOD) error {
 	if err != nil {
 		return fmt.Errorf("failed to read response: %w", err)
 	}
-	if err := json.Unmarshal(body, apod); err != nil {
-		return fmt.Errorf("failed to parse JSON: %w", err)
+	if err := parseJSON(body, resp.Header.Get("Content-Type"), apod); err != nil {
+		return err
 	}
 	if err := os.WriteFile(cachePath, body, 0644); err != nil {
 		log.Printf("warning: failed to cache response: %v\n", err)
@@ -179,100 +1365,584 @@ func fetchAndCacheAPOD(url, cachePath string, apod *APOD) error {
 	return nil
 }
 
+// warnRateLimit prints a warning when the X-RateLimit-Remaining header shows
+// that only a few requests are left, in yellow on terminals
+func warnRateLimit(h http.Header) {
+	remaining, err := strconv.Atoi(h.Get("X-RateLimit-Remaining"))
+	if err != nil || remaining >= rateLimitWarn {
+		return
+	}
+	msg := fmt.Sprintf("warning: only %d API requests left", remaining)
+	if limit := h.Get("X-RateLimit-Limit"); limit != "" {
+		msg += fmt.Sprintf(" of %s per hour", limit)
+	}
+	msg += ", get a personal API key at https://api.nasa.gov/"
+	fmt.Fprintln(os.Stderr, colorize(os.Stderr, colorYellow, msg))
+}
+
 // fetchNASAImage fetches and displays a random NASA image URL
-func fetchNASAImage(query string, setWallpaper bool) error {
-	url := fmt.Sprintf("%s?media_type=image&q=%s", nasaImagesURL, query)
-	resp, err := httpClient.Get(url)
+func fetchNASAImage(query, keywords string, page, pageSize int, setWallpaper bool) error {
+	return fetchImage(func() (imageMeta, error) { return selectNASAImage(query, keywords, page, pageSize) }, setWallpaper)
+}
+
+// searchNASA fetches a page of NASA image search results
+func searchNASA(query, keywords string, page, pageSize int) (NASAImageResponse, error) {
+	params := neturl.Values{}
+	params.Set("media_type", "image")
+	params.Set("q", query)
+	if keywords != "" {
+		params.Set("keywords", keywords)
+	}
+	params.Set("page", strconv.Itoa(page))
+	params.Set("page_size", strconv.Itoa(pageSize))
+	url := nasaImagesURL + "?" + params.Encode()
+	v, err, _ := fetchGroup.Do("nasa:"+url, func() (any, error) {
+		return cachedHTTPGet(httpClient, url, cacheDir, *cacheTTL)
+	})
 	if err != nil {
-		return fmt.Errorf("failed to fetch NASA images: %w", err)
+		return NASAImageResponse{}, fmt.Errorf("failed to fetch NASA images: %w", err)
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("API returned status %d", resp.StatusCode)
+	var nasaResp NASAImageResponse
+	if err := parseJSON(v.([]byte), "", &nasaResp); err != nil {
+		return NASAImageResponse{}, err
 	}
-	body, err := io.ReadAll(resp.Body)
+	return nasaResp, nil
+}
+
+// selectNASAImage picks a random NASA image for a query and optional comma
+// separated keywords and returns its image metadata; page 0 means a random
+// page of results
+func selectNASAImage(query, keywords string, page, pageSize int) (imageMeta, error) {
+	nasaResp, err := searchNASA(query, keywords, max(page, 1), pageSize)
 	if err != nil {
-		return fmt.Errorf("failed to read response: %w", err)
+		return imageMeta{}, err
 	}
-	var nasaResp NASAImageResponse
-	if err := json.Unmarshal(body, &nasaResp); err != nil {
-		return fmt.Errorf("failed to parse JSON: %w", err)
+	if page == 0 {
+		total := min(nasaResp.Collection.Metadata.TotalHits, nasaMaxHits)
+		if pages := (total + pageSize - 1) / pageSize; pages > 1 {
+			if page = 1 + rand.Intn(pages); page > 1 {
+				verbosef("using page %d of %d", page, pages)
+				if nasaResp, err = searchNASA(query, keywords, page, pageSize); err != nil {
+					return imageMeta{}, err
+				}
+			}
+		}
 	}
 	totalHits := nasaResp.Collection.Metadata.TotalHits
 	if totalHits == 0 {
-		return fmt.Errorf("no images found for query: %s", query)
+		return imageMeta{}, fmt.Errorf("no images found for query: %s", query)
 	}
 	items := nasaResp.Collection.Items
 	if len(items) == 0 {
-		return fmt.Errorf("no items in response")
+		return imageMeta{}, fmt.Errorf("no items in response")
+	}
+	items = slices.DeleteFunc(items, func(item NASAImageItem) bool {
+		return len(item.Data) > 0 && !titleAccepted(item.Data[0].Title)
+	})
+	if len(items) == 0 {
+		return imageMeta{}, fmt.Errorf("no items matching title filters")
 	}
 	var (
 		randomIdx = rand.Intn(len(items))
 		item      = items[randomIdx]
 	)
-	collResp, err := httpClient.Get(item.Href)
-	if err != nil {
-		return fmt.Errorf("failed to fetch image collection: %w", err)
-	}
-	defer collResp.Body.Close()
-	collBody, err := io.ReadAll(collResp.Body)
+	collBody, err := cachedHTTPGet(httpClient, item.Href, cacheDir, *cacheTTL)
 	if err != nil {
-		return fmt.Errorf("failed to read collection: %w", err)
+		return imageMeta{}, fmt.Errorf("failed to fetch image collection: %w", err)
 	}
 	var imageURLs NASAImageCollection
-	if err := json.Unmarshal(collBody, &imageURLs); err != nil {
-		return fmt.Errorf("failed to parse collection: %w", err)
+	if err := parseJSON(collBody, "", &imageURLs); err != nil {
+		return imageMeta{}, fmt.Errorf("failed to parse collection: %w", err)
 	}
 	if len(imageURLs) == 0 {
-		return fmt.Errorf("no image URLs in collection")
+		return imageMeta{}, fmt.Errorf("no image URLs in collection")
 	}
-	imageURL := imageURLs[0]
-	fmt.Fprintf(os.Stderr, "%s\n", imageURL)
-	if setWallpaper {
-		imagePath, err := downloadAndCacheImage(imageURL)
-		if err != nil {
-			return fmt.Errorf("failed to download image: %w", err)
-		}
-		if err := setWallpaperImage(imagePath); err != nil {
-			return fmt.Errorf("failed to set wallpaper: %w", err)
+	meta := imageMeta{
+		URL:       normalizeImageURL(imageURLs[0]),
+		MediaType: "image",
+	}
+	if len(item.Data) > 0 {
+		meta.Title = item.Data[0].Title
+		meta.Date = item.Data[0].DateCreated
+		meta.NASAId = item.Data[0].NASAId
+		meta.Explanation = item.Data[0].Description
+	}
+	return meta, nil
+}
+
+// httpResult is a fully read response body along with its content type
+type httpResult struct {
+	Body        []byte
+	ContentType string
+}
+
+// fetchURL fetches a URL and returns the response body
+func fetchURL(url string) (httpResult, error) {
+	start := time.Now()
+	resp, err := getWithRetry(url)
+	bench.observeAPI(start)
+	if err != nil {
+		return httpResult{}, err
+	}
+	defer resp.Body.Close()
+	observeRateLimit(url, resp)
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return httpResult{}, errRateLimited
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return httpResult{}, fmt.Errorf("%w: API returned status %d", errNotFound, resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return httpResult{}, fmt.Errorf("API returned status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return httpResult{}, fmt.Errorf("failed to read response: %w", err)
+	}
+	return httpResult{Body: body, ContentType: resp.Header.Get("Content-Type")}, nil
+}
+
+// getJSON fetches a URL and decodes the JSON response into v
+func getJSON(url string, v any) error {
+	res, err := fetchURL(url)
+	if err != nil {
+		return err
+	}
+	return parseJSON(res.Body, res.ContentType, v)
+}
+
+// parseJSON decodes JSON regardless of the declared content type, since some
+// proxies send JSON as text/html; the content type only ends up in errors
+func parseJSON(data []byte, contentType string, v any) error {
+	if err := json.Unmarshal(data, v); err != nil {
+		if contentType != "" && !strings.Contains(contentType, "json") {
+			return fmt.Errorf("failed to parse JSON (server sent %s): %w", contentType, err)
 		}
+		return fmt.Errorf("failed to parse JSON: %w", err)
 	}
 	return nil
 }
 
-// downloadAndCacheImage downloads an image and caches it locally
-func downloadAndCacheImage(imageURL string) (string, error) {
-	var (
-		hash = sha256.Sum256([]byte(imageURL))
-		ext  = filepath.Ext(imageURL)
-	)
-	if ext == "" {
-		ext = ".jpg"
+// downloadAndCacheImage downloads an image and caches it locally, along with
+// a metadata sidecar; if that fails and the image has a fallback URL, such
+// as the standard definition version of an APOD, that one is used instead
+func downloadAndCacheImage(meta imageMeta) (string, error) {
+	cachePath, err := downloadAndCacheURL(meta)
+	if err == nil || meta.FallbackURL == "" {
+		return cachePath, err
 	}
+	log.Printf("warning: failed to download %s, trying %s: %v\n", meta.URL, meta.FallbackURL, err)
+	meta.URL, meta.FallbackURL = meta.FallbackURL, ""
+	return downloadAndCacheURL(meta)
+}
+
+// downloadAndCacheURL downloads the image at meta.URL and caches it locally,
+// along with a metadata sidecar
+func downloadAndCacheURL(meta imageMeta) (string, error) {
 	var (
-		filename  = fmt.Sprintf("image_%x%s", hash[:8], ext)
-		cachePath = filepath.Join(cacheDir, filename)
+		imageURL  = meta.URL
+		cachePath = imageCachePath(imageURL)
 	)
+	if _, err := os.Stat(cachePath); err == nil && *validateCache && !cacheHitValid(cachePath) {
+		log.Printf("warning: cached image %s is truncated, downloading again\n", cachePath)
+		if err := os.Remove(cachePath); err != nil {
+			return "", fmt.Errorf("failed to remove truncated image: %w", err)
+		}
+	}
 	if _, err := os.Stat(cachePath); err == nil {
+		bench.observeCache(true)
+		if _, err := os.Stat(metaPath(cachePath)); os.IsNotExist(err) {
+			writeImageMeta(cachePath, meta)
+		}
 		return cachePath, nil
 	}
-	resp, err := httpClient.Get(imageURL)
+	bench.observeCache(false)
+	start := time.Now()
+	defer bench.observeDownload(start)
+	var err error
+	for attempt := 0; attempt <= *retries; attempt++ {
+		err = fetchImageFile(imageURL, cachePath)
+		if !errors.Is(err, errChecksumMismatch) && !errors.Is(err, errUndecodable) {
+			break
+		}
+		log.Printf("%v for %s, retrying", err, imageURL)
+	}
 	if err != nil {
-		return "", fmt.Errorf("failed to download image: %w", err)
+		return "", err
+	}
+	if isHEIC(cachePath) {
+		if err := convertHEIC(cachePath); err != nil {
+			os.Remove(cachePath)
+			return "", err
+		}
+	}
+	if *cacheFormat == "" && urlImageExt(imageURL) == "" {
+		if cachePath, err = renameToSniffedExt(cachePath); err != nil {
+			return "", err
+		}
+	}
+	if *cacheFormat != "" {
+		if err := convertImage(cachePath, *cacheFormat); err != nil {
+			os.Remove(cachePath)
+			return "", fmt.Errorf("failed to convert image: %w", err)
+		}
+	}
+	if fi, err := os.Stat(cachePath); err == nil {
+		meta.Size = fi.Size()
+	}
+	if *dedup || *dedupSymlink {
+		if err := dedupImage(cachePath, *dedupSymlink); err != nil {
+			log.Printf("warning: failed to deduplicate image: %v\n", err)
+		}
+	}
+	writeImageMeta(cachePath, meta)
+	indexImage(cachePath, meta)
+	return cachePath, nil
+}
+
+// cacheHitValid reports whether a cached image has the size recorded in its
+// sidecar; images cached before sizes were recorded must decode instead
+func cacheHitValid(cachePath string) bool {
+	fi, err := os.Stat(cachePath)
+	if err != nil {
+		return false
+	}
+	if meta, err := readImageMeta(cachePath); err == nil && meta.Size > 0 {
+		return fi.Size() == meta.Size
+	}
+	return decodeFile(cachePath) == nil
+}
+
+// imageExts are the extensions an image URL may give its cached file;
+// anything else, like the .cgi of a cutout service, is not an image type
+var imageExts = []string{".jpg", ".jpeg", ".png", ".gif", ".webp", ".tif", ".tiff", ".bmp"}
+
+// sniffedExts maps the image types http.DetectContentType knows to the
+// extensions of cached files
+var sniffedExts = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+	"image/bmp":  ".bmp",
+}
+
+// urlImageExt returns the extension of the cached file of an image URL,
+// taken from the URL path, ignoring any query string; it is empty if the
+// path has no known image extension
+func urlImageExt(imageURL string) string {
+	u, err := neturl.Parse(imageURL)
+	if err != nil {
+		return ""
+	}
+	ext := strings.ToLower(filepath.Ext(u.Path))
+	switch {
+	case ext == ".heic" || ext == ".heif":
+		// HEIC images are stored as converted JPEG
+		return ".jpg"
+	case slices.Contains(imageExts, ext):
+		return ext
+	}
+	return ""
+}
+
+// imageCachePath returns the deterministic cache location of an image URL;
+// the extension is taken from the URL path or from -cache-format, which then
+// also goes into the hash; URLs without a known image extension get the one
+// of an already cached file, or .jpg until the download is sniffed; if the
+// sidecar of a path records a different URL, the hash is lengthened until it
+// is unique
+func imageCachePath(imageURL string) string {
+	var (
+		hash = sha256.Sum256([]byte(imageURL))
+		ext  = urlImageExt(imageURL)
+	)
+	if *cacheFormat != "" {
+		hash = sha256.Sum256([]byte(imageURL + "\x00" + *cacheFormat))
+		ext = cacheFormatExt[*cacheFormat]
+	}
+	var path string
+	for n := *hashBytes; ; n *= 2 {
+		n = min(n, len(hash))
+		base := filepath.Join(cacheDir, fmt.Sprintf("image_%x", hash[:n]))
+		if path = base + ext; ext == "" {
+			path = cachedWithSniffedExt(base)
+		}
+		meta, err := readImageMeta(path)
+		if err != nil || meta.URL == "" || meta.URL == imageURL || n == len(hash) {
+			break
+		}
+		log.Printf("warning: cache name collision between %s and %s\n", imageURL, meta.URL)
+	}
+	return path
+}
+
+// cachedWithSniffedExt returns the cached image at base with any sniffed
+// extension, or base with .jpg if there is none yet
+func cachedWithSniffedExt(base string) string {
+	for _, ext := range imageExts {
+		if _, err := os.Stat(base + ext); err == nil {
+			return base + ext
+		}
+	}
+	return base + ".jpg"
+}
+
+// renameToSniffedExt gives a downloaded image the extension of the type
+// detected from its first bytes, returning the new path; unknown types keep
+// their path
+func renameToSniffedExt(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(f, head)
+	f.Close()
+	ext, ok := sniffedExts[http.DetectContentType(head[:n])]
+	if !ok || ext == filepath.Ext(path) {
+		return path, nil
+	}
+	sniffed := strings.TrimSuffix(path, filepath.Ext(path)) + ext
+	if err := os.Rename(path, sniffed); err != nil {
+		return "", fmt.Errorf("failed to rename image: %w", err)
+	}
+	return sniffed, nil
+}
+
+// fetchImageFile downloads an image to a temporary file next to path,
+// verifying the Content-Length and Content-MD5 headers if the server sent
+// them and, with -verify-decode, that the image decodes; only a verified
+// download is renamed to path
+func fetchImageFile(imageURL, path string) error {
+	resp, err := getWithRetry(imageURL)
+	if err != nil {
+		return fmt.Errorf("failed to download image: %w", err)
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("failed to download image, status: %d", resp.StatusCode)
+		return fmt.Errorf("failed to download image, status: %d", resp.StatusCode)
 	}
-	outFile, err := os.Create(cachePath)
+	tmpPath := path + ".part"
+	outFile, err := os.Create(tmpPath)
 	if err != nil {
-		return "", fmt.Errorf("failed to create cache file: %w", err)
+		return fmt.Errorf("failed to create cache file: %w", err)
 	}
+	defer os.Remove(tmpPath)
 	defer outFile.Close()
-	if _, err := io.Copy(outFile, resp.Body); err != nil {
-		return "", fmt.Errorf("failed to save image: %w", err)
+	h := md5.New()
+	n, err := io.Copy(io.MultiWriter(outFile, h), resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to save image: %w", err)
 	}
-	return cachePath, nil
+	if resp.ContentLength >= 0 && n != resp.ContentLength {
+		return fmt.Errorf("truncated download, got %d of %d bytes", n, resp.ContentLength)
+	}
+	if want := resp.Header.Get("Content-MD5"); want != "" {
+		if got := base64.StdEncoding.EncodeToString(h.Sum(nil)); got != want {
+			return errChecksumMismatch
+		}
+	}
+	if err := outFile.Close(); err != nil {
+		return fmt.Errorf("failed to save image: %w", err)
+	}
+	if *verifyDecode {
+		if err := decodeFile(tmpPath); err != nil {
+			return fmt.Errorf("%w: %v", errUndecodable, err)
+		}
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// decodeFile fully decodes the image at path
+func decodeFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, _, err = image.Decode(f)
+	return err
+}
+
+// postProcessImage runs a user supplied shell command template on a cached
+// image and returns the path of the processed file
+func postProcessImage(imagePath, cmdTemplate string) (string, error) {
+	var (
+		output = filepath.Join(cacheDir, "processed_"+filepath.Base(imagePath))
+		r      = strings.NewReplacer("{input}", shellQuote(imagePath), "{output}", shellQuote(output))
+		cmd    = exec.Command("sh", "-c", r.Replace(cmdTemplate))
+	)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(output); err != nil {
+		return "", fmt.Errorf("command did not write %s", output)
+	}
+	return output, nil
+}
+
+// shellQuote quotes s for use as a single word in a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// metaPath returns the path of the metadata sidecar for a cached image
+func metaPath(imagePath string) string {
+	return imagePath + ".json"
+}
+
+// writeImageMeta writes the metadata sidecar for a cached image; failures
+// are only logged, since the image itself is usable without it
+func writeImageMeta(imagePath string, meta imageMeta) {
+	b, err := json.Marshal(meta)
+	if err != nil {
+		log.Printf("warning: failed to encode image metadata: %v\n", err)
+		return
+	}
+	if err := os.WriteFile(metaPath(imagePath), b, 0644); err != nil {
+		log.Printf("warning: failed to write image metadata: %v\n", err)
+	}
+}
+
+// readImageMeta reads the metadata sidecar for a cached image
+func readImageMeta(imagePath string) (imageMeta, error) {
+	var meta imageMeta
+	b, err := os.ReadFile(metaPath(imagePath))
+	if err != nil {
+		return meta, err
+	}
+	if err := json.Unmarshal(b, &meta); err != nil {
+		return meta, fmt.Errorf("failed to parse image metadata: %w", err)
+	}
+	return meta, nil
+}
+
+// cachedImages returns the paths of all images in the cache
+func cachedImages() ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(cacheDir, "image_*"))
+	if err != nil {
+		return nil, err
+	}
+	var images []string
+	for _, m := range matches {
+		switch filepath.Ext(m) {
+		case ".json", ".part", ".tmp":
+			continue
+		}
+		images = append(images, m)
+	}
+	return images, nil
+}
+
+// lastCachedURL returns the original URL of the most recently downloaded
+// image in the cache, from its sidecar
+func lastCachedURL() (string, error) {
+	images, err := cachedImages()
+	if err != nil {
+		return "", err
+	}
+	var (
+		url    string
+		latest time.Time
+	)
+	for _, imagePath := range images {
+		// deduplicated images may be symlinks, their own time is the download
+		fi, err := os.Lstat(imagePath)
+		if err != nil || !fi.ModTime().After(latest) {
+			continue
+		}
+		meta, err := readImageMeta(imagePath)
+		if err != nil || meta.URL == "" {
+			continue
+		}
+		url, latest = meta.URL, fi.ModTime()
+	}
+	if url == "" {
+		return "", fmt.Errorf("no cached image with a recorded URL")
+	}
+	return url, nil
+}
+
+// removeCachedImage removes a cached image and its sidecar; if the image was
+// deduplicated, the shared content file is removed once nothing refers to it
+func removeCachedImage(imagePath string) error {
+	target, _ := contentTarget(imagePath)
+	if err := os.Remove(imagePath); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", imagePath, err)
+	}
+	if err := os.Remove(metaPath(imagePath)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove %s: %w", metaPath(imagePath), err)
+	}
+	unindexImage(imagePath)
+	if target == "" {
+		return nil
+	}
+	referenced, err := contentReferenced(target)
+	if err != nil || referenced {
+		return err
+	}
+	if err := os.Remove(target); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", target, err)
+	}
+	return nil
+}
+
+// pruneVideoImages removes cached images whose sidecar marks them as video
+// thumbnails
+func pruneVideoImages() error {
+	images, err := cachedImages()
+	if err != nil {
+		return err
+	}
+	for _, imagePath := range images {
+		meta, err := readImageMeta(imagePath)
+		if err != nil || meta.MediaType != "video" {
+			continue
+		}
+		if err := removeCachedImage(imagePath); err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, imagePath)
+	}
+	return nil
+}
+
+// setCommandFields are the fields available in -set-command
+type setCommandFields struct {
+	Path      string
+	URL       string
+	Title     string
+	Date      string
+	MediaType string
+}
+
+// runSetCommand sets the wallpaper by running the -set-command template
+// through the shell; Path is shell quoted, so it can be used as is
+func runSetCommand(imagePath string, meta imageMeta) error {
+	absPath, err := filepath.Abs(imagePath)
+	if err != nil {
+		return fmt.Errorf("failed to get absolute path: %w", err)
+	}
+	var (
+		fields = setCommandFields{
+			Path:      shellQuote(absPath),
+			URL:       shellQuote(meta.URL),
+			Title:     shellQuote(meta.Title),
+			Date:      shellQuote(meta.Date),
+			MediaType: shellQuote(meta.MediaType),
+		}
+		sb strings.Builder
+	)
+	if err := setCommandTmpl.Execute(&sb, fields); err != nil {
+		return err
+	}
+	cmd := exec.Command("sh", "-c", sb.String())
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
 }
 
 // setWallpaperImage sets the wallpaper to the given image path
@@ -283,32 +1953,45 @@ func setWallpaperImage(imagePath string) error {
 	}
 	switch runtime.GOOS {
 	case "linux":
-		if err := tryGnome(absPath); err == nil {
-			return nil
-		}
-		if err := tryKDE(absPath); err == nil {
-			return nil
-		}
-		if err := tryXFCE(absPath); err == nil {
-			return nil
-		}
-		if err := tryFeh(absPath); err == nil {
-			return nil
-		}
-		return fmt.Errorf("no supported desktop environment found")
+		return setLinuxWallpaper(absPath)
 	case "darwin":
-		var (
-			script = fmt.Sprintf(`tell application "Finder" to set desktop picture to POSIX file "%s"`, absPath)
-			cmd    = exec.Command("osascript", "-e", script)
-		)
-		return cmd.Run()
+		return exec.Command("osascript", "-e", macWallpaperScript(absPath)).Run()
 	case "windows":
-		return fmt.Errorf("not implemented")
+		return tryWindows(absPath)
 	default:
 		return fmt.Errorf("unsupported OS: %s", runtime.GOOS)
 	}
 }
 
+// macWallpaperScript returns the AppleScript that sets the wallpaper on the
+// desktops selected by -macos-desktop and -macos-all-desktops; Finder only
+// changes the current space
+func macWallpaperScript(imagePath string) string {
+	quoted := `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(imagePath) + `"`
+	switch {
+	case *macDesktop > 0:
+		return fmt.Sprintf(`tell application "System Events" to tell desktop %d to set picture to %s`, *macDesktop, quoted)
+	case *macAllDesktops:
+		return fmt.Sprintf(`tell application "System Events" to tell every desktop to set picture to %s`, quoted)
+	default:
+		return fmt.Sprintf(`tell application "Finder" to set desktop picture to POSIX file %s`, quoted)
+	}
+}
+
+// tryWallutils attempts to set wallpaper using the setwallpaper tool from
+// wallutils, which supports many desktop environments and compositors
+func tryWallutils(imagePath string) error {
+	if _, err := exec.LookPath("setwallpaper"); err != nil {
+		return err
+	}
+	mode := "fill"
+	if *span {
+		mode = "stretch"
+	}
+	cmd := exec.Command("setwallpaper", "-m", mode, imagePath)
+	return cmd.Run()
+}
+
 // tryGnome attempts to set wallpaper using GNOME gsettings
 func tryGnome(imagePath string) error {
 	cmd := exec.Command("gsettings", "set", "org.gnome.desktop.background", "picture-uri", "file://"+imagePath)
@@ -316,21 +1999,78 @@ func tryGnome(imagePath string) error {
 		return err
 	}
 	cmd = exec.Command("gsettings", "set", "org.gnome.desktop.background", "picture-uri-dark", "file://"+imagePath)
+	if err := cmd.Run(); err != nil {
+		return err
+	}
+	if *gnomeTrans != "" {
+		setGnomeTransition(*gnomeTrans)
+	}
+	options := *gnomeOptions
+	if *span {
+		options = "spanned"
+	}
+	cmd = exec.Command("gsettings", "set", "org.gnome.desktop.background", "picture-options", options)
 	return cmd.Run()
 }
 
+// setGnomeTransition sets the transition-type and transition-duration keys
+// from a type[:seconds] value; older GNOME versions lack these keys, so
+// failures are only logged
+func setGnomeTransition(value string) {
+	kind, seconds, _ := strings.Cut(value, ":")
+	cmd := exec.Command("gsettings", "set", "org.gnome.desktop.background", "transition-type", kind)
+	if err := cmd.Run(); err != nil {
+		log.Printf("warning: failed to set GNOME transition type: %v\n", err)
+		return
+	}
+	if seconds == "" {
+		return
+	}
+	cmd = exec.Command("gsettings", "set", "org.gnome.desktop.background", "transition-duration", seconds)
+	if err := cmd.Run(); err != nil {
+		log.Printf("warning: failed to set GNOME transition duration: %v\n", err)
+	}
+}
+
 // tryKDE attempts to set wallpaper using KDE's qdbus
 func tryKDE(imagePath string) error {
-	script := fmt.Sprintf(`
+	cmd := exec.Command("qdbus", "org.kde.plasmashell", "/PlasmaShell", "org.kde.PlasmaShell.evaluateScript", kdeScript(imagePath, *kdeScreen))
+	return cmd.Run()
+}
+
+// kdeScript returns the Plasma script that sets the wallpaper of the desktop
+// containments, of all screens or only those on screen, if not negative
+func kdeScript(imagePath string, screen int) string {
+	var filter string
+	if screen >= 0 {
+		filter = fmt.Sprintf(`
+	if (d.screen != %d) {
+		continue;
+	}`, screen)
+	}
+	return fmt.Sprintf(`
 var allDesktops = desktops();
 for (i=0;i<allDesktops.length;i++) {
-	d = allDesktops[i];
+	d = allDesktops[i];%s
 	d.wallpaperPlugin = "org.kde.image";
 	d.currentConfigGroup = Array("Wallpaper", "org.kde.image", "General");
 	d.writeConfig("Image", "file://%s");
 }
-`, imagePath)
-	cmd := exec.Command("qdbus", "org.kde.plasmashell", "/PlasmaShell", "org.kde.PlasmaShell.evaluateScript", script)
+`, filter, imagePath)
+}
+
+// tryPlasmaTV attempts to set wallpaper on KDE Plasma Bigscreen, which
+// keeps its wallpaper in a separate applets config; only tried when
+// PLASMA_TV_BACKEND is set
+func tryPlasmaTV(imagePath string) error {
+	if os.Getenv("PLASMA_TV_BACKEND") == "" {
+		return fmt.Errorf("PLASMA_TV_BACKEND not set")
+	}
+	cmd := exec.Command("kwriteconfig6",
+		"--file", "plasma-org.kde.plasma.mycroft.bigscreen-appletsrc",
+		"--group", "Containments", "--group", "1",
+		"--group", "Wallpaper", "--group", "org.kde.image", "--group", "General",
+		"--key", "Image", "file://"+imagePath)
 	return cmd.Run()
 }
 
@@ -343,5 +2083,8 @@ func tryXFCE(imagePath string) error {
 // tryFeh attempts to set wallpaper using feh (fallback for many WMs)
 func tryFeh(imagePath string) error {
 	cmd := exec.Command("feh", "--bg-scale", imagePath)
+	if *span {
+		cmd = exec.Command("feh", "--no-xinerama", "--bg-fill", imagePath)
+	}
 	return cmd.Run()
 }
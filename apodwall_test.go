@@ -0,0 +1,540 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// zeros is an endless stream of zero bytes
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// testServerInUse is set while a testServer has the package variables
+var testServerInUse atomic.Bool
+
+// testServer serves the NASA APOD API, the NASA Images API and an image CDN
+// from the fixtures in testdata, with {{server}} in fixtures replaced by the
+// server URL, a cutout service at /cgi-bin/fitscut.cgi and payloads of any
+// size below /blob/; the API endpoints, cache dir and HTTP client are pointed at
+// the server and restored when the test ends. APOD dates other than the
+// fixture date get the 404 the API sends for unpublished dates.
+//
+// The sources read their endpoints and client from package variables, so
+// tests using testServer swap those and must not run in parallel; a second
+// concurrent testServer fails the test.
+func testServer(t testing.TB) *httptest.Server {
+	t.Helper()
+	if !testServerInUse.CompareAndSwap(false, true) {
+		t.Fatal("testServer is already in use, tests using it must not call t.Parallel")
+	}
+	var srv *httptest.Server
+	fixture := func(w http.ResponseWriter, name, contentType string) {
+		b, err := os.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Write(bytes.ReplaceAll(b, []byte("{{server}}"), []byte(srv.URL)))
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/planetary/apod", func(w http.ResponseWriter, r *http.Request) {
+		if date := r.FormValue("date"); date != "" && date != "2024-01-10" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"code":404,"msg":"No data available for date: ` + date + `","service_version":"v1"}`))
+			return
+		}
+		fixture(w, "apod_response.json", "application/json")
+	})
+	mux.HandleFunc("/search", func(w http.ResponseWriter, r *http.Request) {
+		fixture(w, "nasa_search.json", "application/json")
+	})
+	mux.HandleFunc("/asset/", func(w http.ResponseWriter, r *http.Request) {
+		fixture(w, "collection.json", "application/json")
+	})
+	mux.HandleFunc("/image/", func(w http.ResponseWriter, r *http.Request) {
+		fixture(w, "test_image.jpg", "image/jpeg")
+	})
+	mux.HandleFunc("/cgi-bin/fitscut.cgi", func(w http.ResponseWriter, r *http.Request) {
+		fixture(w, "test_image.png", "image/png")
+	})
+	mux.HandleFunc("/blob/", func(w http.ResponseWriter, r *http.Request) {
+		// /blob/<size>/<name>.jpg serves size bytes
+		size, err := strconv.Atoi(strings.Split(strings.TrimPrefix(r.URL.Path, "/blob/"), "/")[0])
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("Content-Length", strconv.Itoa(size))
+		io.CopyN(w, zeros{}, int64(size))
+	})
+	srv = httptest.NewServer(mux)
+	var (
+		oldAPOD, oldNASA = apodURL, nasaImagesURL
+		oldCache, oldCli = cacheDir, httpClient
+	)
+	apodURL, nasaImagesURL = srv.URL+"/planetary/apod", srv.URL+"/search"
+	cacheDir, httpClient = t.TempDir(), srv.Client()
+	t.Cleanup(func() {
+		srv.Close()
+		apodURL, nasaImagesURL = oldAPOD, oldNASA
+		cacheDir, httpClient = oldCache, oldCli
+		testServerInUse.Store(false)
+	})
+	return srv
+}
+
+func TestLoadAPOD(t *testing.T) {
+	srv := testServer(t)
+	apod, err := loadAPOD("DEMO_KEY", "2024-01-10")
+	if err != nil {
+		t.Fatalf("loadAPOD: %v", err)
+	}
+	if want := "The Orion Nebula in Oxygen, Hydrogen, and Sulfur"; apod.Title != want {
+		t.Errorf("got title %q, want %q", apod.Title, want)
+	}
+	if want := srv.URL + "/image/test_image.jpg"; apodImageURL(apod) != want {
+		t.Errorf("got image URL %q, want %q", apodImageURL(apod), want)
+	}
+	if _, err := os.Stat(filepath.Join(cacheDir, "apod_2024-01-10.json")); err != nil {
+		t.Errorf("APOD response not cached: %v", err)
+	}
+	// served from the cache, even with the server gone
+	srv.Close()
+	if _, err := loadAPODOnce("DEMO_KEY", "2024-01-10"); err != nil {
+		t.Errorf("loading cached APOD: %v", err)
+	}
+}
+
+func TestLoadAPODNotPublished(t *testing.T) {
+	testServer(t)
+	if _, err := loadAPOD("DEMO_KEY", "2024-01-11"); !errors.Is(err, errNotPublished) {
+		t.Errorf("got error %v, want %v", err, errNotPublished)
+	}
+}
+
+func TestSelectNASAImage(t *testing.T) {
+	srv := testServer(t)
+	meta, err := selectNASAImage("nebula", "", 0, 100)
+	if err != nil {
+		t.Fatalf("selectNASAImage: %v", err)
+	}
+	if want := srv.URL + "/image/test_image.jpg"; meta.URL != want {
+		t.Errorf("got URL %q, want %q", meta.URL, want)
+	}
+	if meta.Title != "Orion Nebula" || meta.NASAId != "PIA03606" {
+		t.Errorf("got title %q and id %q, want Orion Nebula and PIA03606", meta.Title, meta.NASAId)
+	}
+}
+
+func TestDownloadAndCacheImage(t *testing.T) {
+	srv := testServer(t)
+	meta := imageMeta{URL: srv.URL + "/image/test_image.jpg", Title: "Test"}
+	path, err := downloadAndCacheImage(meta)
+	if err != nil {
+		t.Fatalf("downloadAndCacheImage: %v", err)
+	}
+	want, err := os.ReadFile(filepath.Join("testdata", "test_image.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("cached image differs from served image")
+	}
+	cached, err := readImageMeta(path)
+	if err != nil {
+		t.Fatalf("reading sidecar: %v", err)
+	}
+	if cached.URL != meta.URL || cached.Size != int64(len(want)) {
+		t.Errorf("got sidecar %+v, want URL %s and size %d", cached, meta.URL, len(want))
+	}
+	if !strings.HasPrefix(filepath.Base(path), "image_") {
+		t.Errorf("unexpected cache file name %s", filepath.Base(path))
+	}
+}
+
+func TestCachePathWithoutImageExt(t *testing.T) {
+	srv := testServer(t)
+	imageURL := srv.URL + "/cgi-bin/fitscut.cgi?red=x.fits&size=240&format=png"
+	if ext := filepath.Ext(imageCachePath(imageURL)); ext != ".jpg" {
+		t.Errorf("got extension %s before download, want .jpg", ext)
+	}
+	path, err := downloadAndCacheImage(imageMeta{URL: imageURL, Title: "Test"})
+	if err != nil {
+		t.Fatalf("downloadAndCacheImage: %v", err)
+	}
+	if ext := filepath.Ext(path); ext != ".png" {
+		t.Errorf("got extension %s for a PNG, want .png", ext)
+	}
+	if got := imageCachePath(imageURL); got != path {
+		t.Errorf("got cache path %s after download, want %s", got, path)
+	}
+	if _, err := readImageMeta(path); err != nil {
+		t.Errorf("reading sidecar: %v", err)
+	}
+}
+
+func TestPickImageSkipsHEIC(t *testing.T) {
+	if runtime.GOOS == "darwin" {
+		t.Skip("HEIC images are converted on macOS")
+	}
+	srv := testServer(t)
+	var downloads int
+	heic := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downloads++
+		w.Write([]byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00mif1heic"))
+	}))
+	defer heic.Close()
+	defer func(v bool) { *preferLandsc = v }(*preferLandsc)
+	for _, tt := range []struct {
+		name      string
+		heicURL   string
+		landscape bool
+		downloads int
+	}{
+		// known by the extension, so not downloaded at all
+		{"extension", heic.URL + "/IMG_0001.HEIC", false, 0},
+		// only known by its content, so downloaded once and then remembered
+		{"content", heic.URL + "/photo?id=1", true, 1},
+	} {
+		downloads, *preferLandsc = 0, tt.landscape
+		candidates := []imageMeta{
+			{URL: tt.heicURL, Title: "HEIC"},
+			{URL: tt.heicURL, Title: "HEIC"},
+			{URL: srv.URL + "/image/test_image.jpg", Title: "JPEG"},
+		}
+		var picks int
+		meta, err := pickImage(func() (imageMeta, error) {
+			picks++
+			return candidates[min(picks, len(candidates))-1], nil
+		})
+		if err != nil {
+			t.Fatalf("%s: pickImage: %v", tt.name, err)
+		}
+		if meta.Title != "JPEG" || picks != 3 {
+			t.Errorf("%s: got %q after %d picks, want the JPEG after 3", tt.name, meta.Title, picks)
+		}
+		if downloads != tt.downloads {
+			t.Errorf("%s: HEIC image downloaded %d times, want %d", tt.name, downloads, tt.downloads)
+		}
+	}
+}
+
+func TestWarmupAPODPastDays(t *testing.T) {
+	testServer(t)
+	var dates []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dates = append(dates, r.FormValue("date"))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"code":404,"msg":"No data available for date: ` + r.FormValue("date") + `"}`))
+	}))
+	defer srv.Close()
+	apodURL = srv.URL + "/planetary/apod"
+	if err := warmupAPOD("DEMO_KEY", 2); err != nil {
+		t.Fatalf("warmupAPOD: %v", err)
+	}
+	now := apodNow()
+	var want []string
+	for i := range 3 {
+		want = append(want, now.AddDate(0, 0, -i).Format("2006-01-02"))
+	}
+	if !slices.Equal(dates, want) {
+		t.Errorf("warmed up %v, want %v", dates, want)
+	}
+}
+
+func TestDecodeFileFormats(t *testing.T) {
+	for _, name := range []string{"test_image.bmp", "test_image.tiff"} {
+		if !slices.Contains(imageExts, filepath.Ext(name)) {
+			t.Errorf("%s is not a cached image extension", filepath.Ext(name))
+		}
+		if err := decodeFile(filepath.Join("testdata", name)); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestValidateCacheHitTIFF(t *testing.T) {
+	srv := testServer(t)
+	defer func(v bool) { *validateCache = v }(*validateCache)
+	*validateCache = true
+	// the server has no such image, so a cache miss fails
+	meta := imageMeta{URL: srv.URL + "/missing/test_image.tiff", Title: "Test"}
+	path := imageCachePath(meta.URL)
+	b, err := os.ReadFile(filepath.Join("testdata", "test_image.tiff"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// a sidecar without a size, as written before sizes were recorded
+	writeImageMeta(path, meta)
+	got, err := downloadAndCacheImage(meta)
+	if err != nil {
+		t.Fatalf("cached TIFF was not accepted: %v", err)
+	}
+	if got != path {
+		t.Errorf("got %s, want the cached %s", got, path)
+	}
+}
+
+func TestPickImageFixedDate(t *testing.T) {
+	testServer(t)
+	defer func(d string, p bool) { *apodDay, *preferPortrait = d, p }(*apodDay, *preferPortrait)
+	// the fixture image is landscape
+	*apodDay, *preferPortrait = "2024-01-10", true
+	var picks int
+	_, err := pickImage(func() (imageMeta, error) {
+		picks++
+		return selectAPOD("DEMO_KEY")
+	})
+	if err == nil || !strings.Contains(err.Error(), "-d") {
+		t.Errorf("got %v, want an error naming the fixed date", err)
+	}
+	if picks != 1 {
+		t.Errorf("fixed date was picked %d times, want once", picks)
+	}
+}
+
+// jsonSeeds are fuzz seeds for the JSON parsers besides the fixture: null
+// and missing fields, wrong types and broken documents
+var jsonSeeds = []string{
+	`{}`,
+	`null`,
+	`[]`,
+	`{"date":null,"url":null,"hdurl":null,"media_type":null}`,
+	`{"date":"2024-01-10","media_type":"video","url":"https://www.youtube.com/embed/x","thumbnail_url":"https://img.youtube.com/vi/x/0.jpg"}`,
+	`{"title":123,"url":["a"],"hdurl":{}}`,
+	`{"collection":{"items":null,"metadata":{"total_hits":"many"}}}`,
+	`{"collection":{"items":[{"href":1,"data":[{"title":null}]}],"metadata":{"total_hits":-1}}}`,
+	`{"collection":{"items":[{"data":[]}],"metadata":{}}}`,
+	`{"title":"unterminated`,
+	`<html><body>502 Bad Gateway</body></html>`,
+}
+
+// addJSONSeeds adds the fixture and the jsonSeeds to a fuzz corpus
+func addJSONSeeds(f *testing.F, fixture string) {
+	b, err := os.ReadFile(filepath.Join("testdata", fixture))
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(bytes.ReplaceAll(b, []byte("{{server}}"), []byte("https://example.com")))
+	for _, s := range jsonSeeds {
+		f.Add([]byte(s))
+	}
+}
+
+// checkRoundTrip fails if v does not survive encoding and decoding again,
+// which would mean parsing kept data the types cannot represent
+func checkRoundTrip[T any](t *testing.T, v T) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("encoding parsed value: %v", err)
+	}
+	var again T
+	if err := json.Unmarshal(b, &again); err != nil {
+		t.Fatalf("decoding encoded value: %v", err)
+	}
+	if !reflect.DeepEqual(v, again) {
+		t.Fatalf("round trip changed value: %+v became %+v", v, again)
+	}
+}
+
+func FuzzParseAPOD(f *testing.F) {
+	addJSONSeeds(f, "apod_response.json")
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var apod APOD
+		if err := parseJSON(data, "application/json", &apod); err != nil {
+			return
+		}
+		checkRoundTrip(t, apod)
+		meta := apodMeta(apod)
+		if meta.Title != apod.Title || meta.Date != apod.Date {
+			t.Fatalf("metadata %+v does not match APOD %+v", meta, apod)
+		}
+	})
+}
+
+func FuzzParseNASAImageResponse(f *testing.F) {
+	addJSONSeeds(f, "nasa_search.json")
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var resp NASAImageResponse
+		if err := parseJSON(data, "application/json", &resp); err != nil {
+			return
+		}
+		checkRoundTrip(t, resp)
+		for _, item := range resp.Collection.Items {
+			for _, d := range item.Data {
+				titleAccepted(d.Title)
+			}
+		}
+	})
+}
+
+func BenchmarkDownloadAndCacheImage(b *testing.B) {
+	srv := testServer(b)
+	for _, mb := range []int{1, 10, 50} {
+		b.Run(fmt.Sprintf("%dMB", mb), func(b *testing.B) {
+			size := mb << 20
+			b.SetBytes(int64(size))
+			for i := 0; b.Loop(); i++ {
+				// a new URL each time, so nothing comes from the cache
+				meta := imageMeta{URL: fmt.Sprintf("%s/blob/%d/%d.jpg", srv.URL, size, i)}
+				path, err := downloadAndCacheImage(meta)
+				if err != nil {
+					b.Fatalf("downloadAndCacheImage: %v", err)
+				}
+				os.Remove(path)
+				os.Remove(metaPath(path))
+			}
+		})
+	}
+}
+
+func TestFetchAndCacheAPODContentType(t *testing.T) {
+	fixture, err := os.ReadFile(filepath.Join("testdata", "apod_response.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		about   string
+		body    []byte
+		wantErr string
+	}{
+		{"valid JSON sent as HTML, as by some proxies", fixture, ""},
+		{"HTML error page", []byte("<html><body><h1>Service Unavailable</h1></body></html>"), "server sent text/html"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.about, func(t *testing.T) {
+			testServer(t) // for the HTTP client and cache dir
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				w.Write(tt.body)
+			}))
+			defer srv.Close()
+			var (
+				cachePath = filepath.Join(t.TempDir(), "apod.json")
+				apod      APOD
+			)
+			err := fetchAndCacheAPOD(srv.URL, cachePath, &apod)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("got error %v, want none", err)
+			case tt.wantErr == "" && apod.Date != "2024-01-10":
+				t.Errorf("got date %q, want 2024-01-10", apod.Date)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("got error %v, want one mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSelectAPODDayFallback(t *testing.T) {
+	testServer(t)
+	// the server only has 2024-01-10, so 2024-01-11 is not published yet
+	day := time.Date(2024, 1, 11, 6, 0, 0, 0, time.UTC)
+	meta, err := selectAPODDay("DEMO_KEY", day)
+	if err != nil {
+		t.Fatalf("selectAPODDay: %v", err)
+	}
+	if meta.Date != "2024-01-10" {
+		t.Errorf("got date %q, want the day before, 2024-01-10", meta.Date)
+	}
+	if _, err := selectAPODDay("DEMO_KEY", day.AddDate(0, 0, 1)); !errors.Is(err, errNotPublished) {
+		t.Errorf("got error %v, want %v when the day before is missing too", err, errNotPublished)
+	}
+}
+
+func TestKDEScript(t *testing.T) {
+	var tests = []struct {
+		screen int
+		want   string
+		reject string
+	}{
+		{-1, `d.writeConfig("Image", "file:///tmp/a.jpg");`, "d.screen"},
+		{0, "if (d.screen != 0) {\n\t\tcontinue;\n\t}", ""},
+		{2, "if (d.screen != 2) {\n\t\tcontinue;\n\t}", "d.screen != 0"},
+	}
+	for _, tt := range tests {
+		script := kdeScript("/tmp/a.jpg", tt.screen)
+		if !strings.Contains(script, tt.want) {
+			t.Errorf("screen %d: script does not contain %q:\n%s", tt.screen, tt.want, script)
+		}
+		if tt.reject != "" && strings.Contains(script, tt.reject) {
+			t.Errorf("screen %d: script contains %q:\n%s", tt.screen, tt.reject, script)
+		}
+		if !strings.Contains(script, "desktops()") {
+			t.Errorf("screen %d: script does not iterate desktops():\n%s", tt.screen, script)
+		}
+	}
+}
+
+func TestHistoryRecordsShownAPOD(t *testing.T) {
+	testServer(t)
+	apod, err := loadAPOD("DEMO_KEY", "2024-01-10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fetchImage(func() (imageMeta, error) { return apodMeta(apod), nil }, false); err != nil {
+		t.Fatalf("fetchImage: %v", err)
+	}
+	other := imageMeta{URL: "https://example.com/a.jpg", Date: "2024-01-09"}
+	if err := fetchImage(func() (imageMeta, error) { return other, nil }, false); err != nil {
+		t.Fatalf("fetchImage: %v", err)
+	}
+	if got := loadHistory(); !slices.Equal(got, []string{"2024-01-10"}) {
+		t.Errorf("got history %v, want only the shown APOD date", got)
+	}
+}
+
+func TestAPODVideoThumbnail(t *testing.T) {
+	video := APOD{
+		Date:         "2024-01-12",
+		MediaType:    "video",
+		URL:          "https://www.youtube.com/embed/abc?rel=0",
+		ThumbnailURL: "https://img.youtube.com/vi/abc/0.jpg",
+	}
+	defer func(v bool) { *allowVideo = v }(*allowVideo)
+	*allowVideo = false
+	if apodUsable(video) {
+		t.Errorf("video usable without -allow-video")
+	}
+	*allowVideo = true
+	if !apodUsable(video) {
+		t.Errorf("video with thumbnail not usable with -allow-video")
+	}
+	if apodUsable(APOD{MediaType: "video"}) {
+		t.Errorf("video without thumbnail usable")
+	}
+	meta := apodMeta(video)
+	if meta.URL != video.ThumbnailURL || meta.MediaType != "video" {
+		t.Errorf("got URL %q and media type %q, want the thumbnail and video", meta.URL, meta.MediaType)
+	}
+}
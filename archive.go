@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+)
+
+// outputTemplate is the parsed -output-dir-template, nil if unset
+var outputTemplate *template.Template
+
+// archiveFields are the fields available in -output-dir-template
+type archiveFields struct {
+	Year   string
+	Month  string
+	Day    string
+	Title  string
+	NASAId string
+}
+
+// compileOutputTemplate parses the -output-dir-template flag
+func compileOutputTemplate() (err error) {
+	outputTemplate = nil
+	if *outputTmpl == "" {
+		return nil
+	}
+	if outputTemplate, err = template.New("output").Option("missingkey=error").Parse(*outputTmpl); err != nil {
+		return fmt.Errorf("invalid -output-dir-template: %w", err)
+	}
+	return nil
+}
+
+// archiveImage copies a cached image into the download directory and returns
+// the path of the copy
+func archiveImage(imagePath string, meta imageMeta) (string, error) {
+	rel, err := archivePath(imagePath, meta)
+	if err != nil {
+		return "", err
+	}
+	dst := filepath.Join(*downloadDir, rel)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return "", err
+	}
+	if err := copyFile(imagePath, dst); err != nil {
+		return "", err
+	}
+	return dst, nil
+}
+
+// archivePath returns the path of an image relative to the download
+// directory, as determined by the output template
+func archivePath(imagePath string, meta imageMeta) (string, error) {
+	if outputTemplate == nil {
+		return filepath.Base(imagePath), nil
+	}
+	var (
+		t      = parseMetaDate(meta.Date)
+		fields = archiveFields{
+			Year:   t.Format("2006"),
+			Month:  t.Format("01"),
+			Day:    t.Format("02"),
+			Title:  slugify(meta.Title),
+			NASAId: slugify(meta.NASAId),
+		}
+		sb strings.Builder
+	)
+	if err := outputTemplate.Execute(&sb, fields); err != nil {
+		return "", err
+	}
+	rel := filepath.Clean(strings.TrimSpace(sb.String()))
+	if rel == "." || filepath.IsAbs(rel) || strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("template yields invalid path: %q", sb.String())
+	}
+	return rel + filepath.Ext(imagePath), nil
+}
+
+// parseMetaDate parses the various date formats found in image metadata,
+// falling back to the current time
+func parseMetaDate(s string) time.Time {
+	if len(s) >= 10 {
+		if t, err := time.Parse("2006-01-02", s[:10]); err == nil {
+			return t
+		}
+	}
+	for _, layout := range []string{time.RFC1123Z, time.RFC1123} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Now()
+}
+
+// slugify turns a title into a lowercase, dash separated file name component
+func slugify(s string) string {
+	var (
+		sb   strings.Builder
+		dash bool
+	)
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && sb.Len() > 0 {
+				sb.WriteByte('-')
+			}
+			sb.WriteRune(r)
+			dash = false
+			continue
+		}
+		dash = true
+	}
+	return sb.String()
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// aspectModes are the accepted -aspect-mode values
+var aspectModes = []string{"crop", "fit", "letterbox"}
+
+// fitRect returns the largest rectangle with the aspect ratio of src that
+// fits into w by h, centered
+func fitRect(src image.Rectangle, w, h int) image.Rectangle {
+	var (
+		sw, sh = src.Dx(), src.Dy()
+		fw, fh = w, sh * w / sw
+	)
+	if fh > h {
+		fw, fh = sw*h/sh, h
+	}
+	x, y := (w-fw)/2, (h-fh)/2
+	return image.Rect(x, y, x+fw, y+fh)
+}
+
+// averageColor returns the mean color of a downsampled version of img
+func averageColor(img image.Image) color.RGBA {
+	var (
+		b        = img.Bounds()
+		r, g, bl float64
+		n        float64
+		stepX    = max(1, b.Dx()/64)
+		stepY    = max(1, b.Dy()/64)
+	)
+	for y := b.Min.Y; y < b.Max.Y; y += stepY {
+		for x := b.Min.X; x < b.Max.X; x += stepX {
+			c := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			r, g, bl = r+float64(c.R), g+float64(c.G), bl+float64(c.B)
+			n++
+		}
+	}
+	return color.RGBA{uint8(r / n), uint8(g / n), uint8(bl / n), 255}
+}
+
+// primaryScreenSize returns the resolution of the primary monitor, from
+// listMonitors on Linux, system_profiler on macOS and the forms API through
+// PowerShell on Windows
+func primaryScreenSize() (int, int, error) {
+	switch runtime.GOOS {
+	case "linux":
+		monitors, err := listMonitors()
+		if err != nil {
+			return 0, 0, err
+		}
+		m := primaryMonitor(monitors)
+		return m.w, m.h, nil
+	case "darwin":
+		out, err := exec.Command("system_profiler", "SPDisplaysDataType").Output()
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to list displays: %w", err)
+		}
+		return parseMacDisplays(string(out))
+	case "windows":
+		out, err := exec.Command("powershell", "-NoProfile", "-Command",
+			`Add-Type -AssemblyName System.Windows.Forms; $b = [System.Windows.Forms.Screen]::PrimaryScreen.Bounds; "$($b.Width)x$($b.Height)"`).Output()
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to query the primary screen: %w", err)
+		}
+		w, h, ok := parseSize(strings.TrimSpace(string(out)))
+		if !ok {
+			return 0, 0, fmt.Errorf("unexpected screen size %q", strings.TrimSpace(string(out)))
+		}
+		return w, h, nil
+	default:
+		return 0, 0, fmt.Errorf("unsupported OS: %s", runtime.GOOS)
+	}
+}
+
+// parseMacDisplays returns the resolution of the main display in the output
+// of system_profiler SPDisplaysDataType, where "Main Display: Yes" follows
+// the "Resolution: 2560 x 1600" line of its display, or of the first display
+func parseMacDisplays(out string) (int, int, error) {
+	var w, h, firstW, firstH int
+	for _, line := range strings.Split(out, "\n") {
+		key, value, found := strings.Cut(strings.TrimSpace(line), ": ")
+		if !found {
+			continue
+		}
+		switch {
+		case key == "Resolution":
+			fields := strings.Fields(value)
+			if len(fields) < 3 {
+				continue
+			}
+			if rw, rh, ok := parseSize(fields[0] + "x" + fields[2]); ok {
+				w, h = rw, rh
+				if firstW == 0 {
+					firstW, firstH = rw, rh
+				}
+			}
+		case key == "Main Display" && value == "Yes" && w > 0:
+			return w, h, nil
+		}
+	}
+	if firstW == 0 {
+		return 0, 0, fmt.Errorf("no displays found")
+	}
+	return firstW, firstH, nil
+}
+
+// parseSize parses a size like 1920x1080
+func parseSize(s string) (int, int, bool) {
+	ws, hs, found := strings.Cut(s, "x")
+	if !found {
+		return 0, 0, false
+	}
+	w, err := strconv.Atoi(ws)
+	if err != nil || w <= 0 {
+		return 0, 0, false
+	}
+	h, err := strconv.Atoi(hs)
+	if err != nil || h <= 0 {
+		return 0, 0, false
+	}
+	return w, h, true
+}
+
+// aspectImage scales an image to the size of the primary monitor according
+// to mode: crop fills the screen and cuts off the overhang, fit fills the
+// gaps with the average color of the image and letterbox with black; returns
+// the path of the image to use, which is the input if the aspect ratios
+// already match or the screen size cannot be detected
+func aspectImage(imagePath, mode string) (string, error) {
+	screenW, screenH, err := primaryScreenSize()
+	if err != nil {
+		log.Printf("warning: failed to detect the screen size, leaving the image as it is: %v\n", err)
+		return imagePath, nil
+	}
+	f, err := os.Open(imagePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	src, _, err := image.Decode(f)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode image: %w", err)
+	}
+	var (
+		sb          = src.Bounds()
+		imageRatio  = float64(sb.Dx()) / float64(sb.Dy())
+		screenRatio = float64(screenW) / float64(screenH)
+	)
+	if math.Abs(imageRatio-screenRatio) < 0.01*screenRatio {
+		return imagePath, nil
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, screenW, screenH))
+	switch mode {
+	case "crop":
+		draw.CatmullRom.Scale(dst, dst.Bounds(), src, coverRect(sb, screenW, screenH), draw.Src, nil)
+	case "fit", "letterbox":
+		bg := color.RGBA{A: 255}
+		if mode == "fit" {
+			bg = averageColor(src)
+		}
+		draw.Draw(dst, dst.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+		draw.CatmullRom.Scale(dst, fitRect(sb, screenW, screenH), src, sb, draw.Src, nil)
+	default:
+		return "", fmt.Errorf("unknown aspect mode: %s", mode)
+	}
+	var (
+		base    = strings.TrimSuffix(filepath.Base(imagePath), filepath.Ext(imagePath))
+		outPath = filepath.Join(cacheDir, fmt.Sprintf("aspect_%s_%s_%dx%d.jpg", mode, base, screenW, screenH))
+	)
+	out, err := os.Create(outPath)
+	if err != nil {
+		return "", err
+	}
+	if err := jpeg.Encode(out, dst, &jpeg.Options{Quality: 92}); err != nil {
+		out.Close()
+		return "", err
+	}
+	return outPath, out.Close()
+}
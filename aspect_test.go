@@ -0,0 +1,14 @@
+package main
+
+import "testing"
+
+func TestParseMacDisplays(t *testing.T) {
+	out := "Displays:\n        DELL U2720Q:\n          Resolution: 3840 x 2160 (2160p/4K UHD 1 - Ultra High Definition)\n        Color LCD:\n          Resolution: 3024 x 1964 Retina\n          Main Display: Yes\n"
+	w, h, err := parseMacDisplays(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w != 3024 || h != 1964 {
+		t.Errorf("got %dx%d, want the main display at 3024x1964", w, h)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// backend is a Linux wallpaper setter along with the programs it runs
+type backend struct {
+	set   func(string) error
+	tools []string
+}
+
+// linuxBackends are the Linux wallpaper setters that can be selected by name
+var linuxBackends = map[string]backend{
+	"wallutils": {tryWallutils, []string{"setwallpaper"}},
+	"wayland":   {tryWayland, []string{"swaybg", "wbg"}},
+	"sway":      {trySway, []string{"swaybg"}},
+	"wbg":       {tryWbg, []string{"wbg"}},
+	"gnome":     {tryGnome, []string{"gsettings"}},
+	"plasmatv":  {tryPlasmaTV, []string{"kwriteconfig6"}},
+	"kde":       {tryKDE, []string{"qdbus"}},
+	"xfce":      {tryXFCE, []string{"xfconf-query"}},
+	"feh":       {tryFeh, []string{"feh"}},
+}
+
+var (
+	// x11Backends is the default probe order in X11 sessions
+	x11Backends = []string{"wallutils", "gnome", "plasmatv", "kde", "xfce", "feh"}
+	// waylandBackends is the default probe order in Wayland sessions, where
+	// feh only sets the invisible X11 root window
+	waylandBackends = []string{"wallutils", "wayland", "gnome", "plasmatv", "kde", "xfce"}
+)
+
+// backendOrder is the parsed -backends list
+var backendOrder []string
+
+// compileBackends parses -backends, a comma separated list of Linux
+// wallpaper setters to try in order
+func compileBackends() error {
+	backendOrder = nil
+	for _, name := range strings.Split(*backendList, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if _, ok := linuxBackends[name]; !ok {
+			names := make([]string, 0, len(linuxBackends))
+			for k := range linuxBackends {
+				names = append(names, k)
+			}
+			slices.Sort(names)
+			return fmt.Errorf("unknown backend %q in -backends, want one of %s", name, strings.Join(names, ", "))
+		}
+		backendOrder = append(backendOrder, name)
+	}
+	return nil
+}
+
+// linuxBackendOrder returns the setters to try, from -backends or depending
+// on the session type
+func linuxBackendOrder() []string {
+	switch {
+	case len(backendOrder) > 0:
+		return backendOrder
+	case isWayland():
+		return waylandBackends
+	default:
+		return x11Backends
+	}
+}
+
+// linuxBackendTools returns the programs of all Linux wallpaper setters,
+// those of the default probe order first
+func linuxBackendTools() []string {
+	var tools []string
+	for _, name := range slices.Concat(linuxBackendOrder(), x11Backends, waylandBackends, slices.Sorted(maps.Keys(linuxBackends))) {
+		for _, tool := range linuxBackends[name].tools {
+			if !slices.Contains(tools, tool) {
+				tools = append(tools, tool)
+			}
+		}
+	}
+	return tools
+}
+
+// setLinuxWallpaper tries the Linux wallpaper setters in order until one
+// succeeds
+func setLinuxWallpaper(imagePath string) error {
+	for _, name := range linuxBackendOrder() {
+		err := linuxBackends[name].set(imagePath)
+		if err == nil {
+			verbosef("wallpaper set with %s", name)
+			return nil
+		}
+		verbosef("%s: %v", name, err)
+	}
+	return fmt.Errorf("no supported desktop environment found")
+}
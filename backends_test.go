@@ -0,0 +1,25 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestWallpaperToolsCoverBackends(t *testing.T) {
+	tools := wallpaperTools("linux")
+	for name, b := range linuxBackends {
+		for _, tool := range b.tools {
+			if !slices.Contains(tools, tool) {
+				t.Errorf("wizard does not check %s of the %s backend", tool, name)
+			}
+		}
+	}
+	for _, tool := range []string{"swaybg", "wbg"} {
+		if !slices.Contains(tools, tool) {
+			t.Errorf("wizard does not check %s", tool)
+		}
+	}
+	if sorted := slices.Sorted(slices.Values(tools)); len(slices.Compact(sorted)) != len(tools) {
+		t.Errorf("duplicate tools in %v", tools)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"time"
+)
+
+// bench collects timings when running with -bench, nil otherwise
+var bench *benchStats
+
+// benchStats records request latencies and cache usage
+type benchStats struct {
+	api      []time.Duration
+	download []time.Duration
+	hits     int
+	lookups  int
+}
+
+// observeAPI records the duration of an API request started at start
+func (b *benchStats) observeAPI(start time.Time) {
+	if b == nil {
+		return
+	}
+	b.api = append(b.api, time.Since(start))
+}
+
+// observeDownload records the duration of an image download started at start
+func (b *benchStats) observeDownload(start time.Time) {
+	if b == nil {
+		return
+	}
+	b.download = append(b.download, time.Since(start))
+}
+
+// observeCache records a cache lookup
+func (b *benchStats) observeCache(hit bool) {
+	if b == nil {
+		return
+	}
+	b.lookups++
+	if hit {
+		b.hits++
+	}
+}
+
+// report writes a latency summary to w
+func (b *benchStats) report(w io.Writer) {
+	fmt.Fprintf(w, "%-10s %6s %10s %10s %10s %10s\n", "", "n", "min", "median", "p95", "max")
+	writeLatencies(w, "api", b.api)
+	writeLatencies(w, "download", b.download)
+	rate := 0.0
+	if b.lookups > 0 {
+		rate = float64(b.hits) / float64(b.lookups) * 100
+	}
+	fmt.Fprintf(w, "cache hits: %d/%d (%.1f%%)\n", b.hits, b.lookups, rate)
+}
+
+// writeLatencies writes a single summary line for a set of durations
+func writeLatencies(w io.Writer, name string, ds []time.Duration) {
+	if len(ds) == 0 {
+		fmt.Fprintf(w, "%-10s %6d %10s %10s %10s %10s\n", name, 0, "-", "-", "-", "-")
+		return
+	}
+	sorted := append([]time.Duration(nil), ds...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var (
+		n      = len(sorted)
+		median = sorted[n/2]
+		p95    = sorted[(n*95+99)/100-1]
+	)
+	if n%2 == 0 {
+		median = (sorted[n/2-1] + sorted[n/2]) / 2
+	}
+	fmt.Fprintf(w, "%-10s %6d %10s %10s %10s %10s\n", name, n,
+		sorted[0].Round(time.Millisecond), median.Round(time.Millisecond),
+		p95.Round(time.Millisecond), sorted[n-1].Round(time.Millisecond))
+}
+
+// runBench runs n fetch cycles, downloading but not setting images, and
+// reports latencies; stops early when an API signals a rate limit
+func runBench(apiKey string, n int) error {
+	bench = &benchStats{}
+	for i := 0; i < n; i++ {
+		meta, err := selectImage(apiKey)
+		if err == nil {
+			_, err = downloadAndCacheImage(meta)
+		}
+		if errors.Is(err, errRateLimited) {
+			log.Printf("rate limited after %d cycles, stopping", i)
+			break
+		}
+		if err != nil {
+			log.Printf("cycle %d failed: %v", i+1, err)
+		}
+	}
+	bench.report(os.Stdout)
+	return nil
+}
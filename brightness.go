@@ -0,0 +1,70 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+)
+
+const (
+	// brightnessSamples is the number of pixels sampled along each axis
+	brightnessSamples = 64
+	darkThreshold     = 0.3
+	brightThreshold   = 0.7
+)
+
+// preferredBrightness returns the brightness class requested by flags, or
+// an empty string if there is no preference
+func preferredBrightness() string {
+	switch {
+	case *preferBright:
+		return "bright"
+	case *preferDark:
+		return "dark"
+	default:
+		return ""
+	}
+}
+
+// classifyImageBrightness classifies an image as dark, medium or bright by
+// the mean luminance of a downsampled grid of pixels
+func classifyImageBrightness(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return "", err
+	}
+	var (
+		b     = img.Bounds()
+		stepX = max(b.Dx()/brightnessSamples, 1)
+		stepY = max(b.Dy()/brightnessSamples, 1)
+		sum   float64
+		n     int
+	)
+	for y := b.Min.Y; y < b.Max.Y; y += stepY {
+		for x := b.Min.X; x < b.Max.X; x += stepX {
+			r, g, bl, _ := img.At(x, y).RGBA()
+			luma, _, _ := color.RGBToYCbCr(uint8(r>>8), uint8(g>>8), uint8(bl>>8))
+			sum += float64(luma) / 255
+			n++
+		}
+	}
+	if n == 0 {
+		return "medium", nil
+	}
+	switch mean := sum / float64(n); {
+	case mean < darkThreshold:
+		return "dark", nil
+	case mean > brightThreshold:
+		return "bright", nil
+	default:
+		return "medium", nil
+	}
+}
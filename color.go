@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ANSI color codes
+const (
+	colorRed    = "\033[31m"
+	colorYellow = "\033[33m"
+	colorCyan   = "\033[36m"
+	colorReset  = "\033[0m"
+)
+
+// useColor reports whether output to f may be colored: f is a terminal and
+// NO_COLOR is not set
+func useColor(f *os.File) bool {
+	return isTerminal(f) && os.Getenv("NO_COLOR") == ""
+}
+
+// colorize wraps s in a color, if output to f may be colored
+func colorize(f *os.File, color, s string) string {
+	if !useColor(f) {
+		return s
+	}
+	return color + s + colorReset
+}
+
+// printError prints an error message to stderr, in red on terminals
+func printError(format string, args ...any) {
+	msg := strings.TrimSuffix(fmt.Sprintf(format, args...), "\n")
+	fmt.Fprintln(os.Stderr, colorize(os.Stderr, colorRed, msg))
+}
+
+// warningWriter colors log lines containing a warning in yellow
+type warningWriter struct {
+	w io.Writer
+}
+
+func (ww warningWriter) Write(p []byte) (int, error) {
+	if !bytes.Contains(p, []byte("warning:")) {
+		return ww.w.Write(p)
+	}
+	line := colorYellow + string(bytes.TrimSuffix(p, []byte("\n"))) + colorReset + "\n"
+	if _, err := io.WriteString(ww.w, line); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
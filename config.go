@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/adrg/xdg"
+	"github.com/fsnotify/fsnotify"
+)
+
+// configDebounce is how long to wait for more writes before reloading
+const configDebounce = 500 * time.Millisecond
+
+// configFlags are the flags currently set from the config file
+var configFlags = make(map[string]bool)
+
+// cmdlineFlags are the flags given on the command line, recorded before the
+// config is first applied; flag.Visit cannot tell them apart later, since
+// resetting a flag to its default also counts as setting it
+var cmdlineFlags map[string]bool
+
+// configFile returns the location of the config file
+func configFile() string {
+	if *configPath != "" {
+		return *configPath
+	}
+	return filepath.Join(xdg.ConfigHome, cacheSubdir, "config")
+}
+
+// parseConfig reads a config file with lines like "name = value", where name
+// is a flag name without the dash; empty lines and # comments are ignored
+func parseConfig(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var (
+		values  = make(map[string]string)
+		scanner = bufio.NewScanner(f)
+		lineno  int
+	)
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected name = value", path, lineno)
+		}
+		k = strings.TrimPrefix(strings.TrimSpace(k), "-")
+		v = strings.TrimSpace(v)
+		if len(v) >= 2 && v[0] == '"' && v[len(v)-1] == '"' {
+			v = v[1 : len(v)-1]
+		}
+		values[k] = v
+	}
+	return values, scanner.Err()
+}
+
+// loadConfig applies the config file to all flags not given on the command
+// line; flags previously set from the config but no longer in it are reset
+// to their defaults. A missing config file is not an error.
+func loadConfig(path string) error {
+	values, err := parseConfig(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+	if cmdlineFlags == nil {
+		cmdlineFlags = make(map[string]bool)
+		flag.Visit(func(f *flag.Flag) { cmdlineFlags[f.Name] = true })
+	}
+	for name := range configFlags {
+		if _, ok := values[name]; !ok {
+			if f := flag.Lookup(name); f != nil {
+				f.Value.Set(f.DefValue)
+			}
+			delete(configFlags, name)
+		}
+	}
+	for name, value := range values {
+		if name == "config" || cmdlineFlags[name] {
+			continue
+		}
+		if flag.Lookup(name) == nil {
+			return fmt.Errorf("%s: unknown option %q", path, name)
+		}
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("%s: invalid value for %s: %w", path, name, err)
+		}
+		configFlags[name] = true
+	}
+	return nil
+}
+
+// reloadConfig re-reads the config file and reruns setup; on failure the
+// error is returned and the daemon keeps running
+func reloadConfig() error {
+	if err := loadConfig(configFile()); err != nil {
+		return err
+	}
+	return setup()
+}
+
+// watchConfigFile sends on the returned channel whenever the config file
+// has been written, debouncing rapid successive writes; the directory is
+// watched, since editors often replace the file. If the file cannot be
+// watched, a warning is logged and the channel never fires.
+func watchConfigFile(path string) <-chan struct{} {
+	ch := make(chan struct{}, 1)
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Printf("warning: cannot watch config: %v", err)
+		return ch
+	}
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		log.Printf("warning: cannot watch config: %v", err)
+		watcher.Close()
+		return ch
+	}
+	go func() {
+		var timer *time.Timer
+		for {
+			select {
+			case ev, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(ev.Name) != filepath.Clean(path) || !ev.Has(fsnotify.Write|fsnotify.Create) {
+					continue
+				}
+				if timer != nil {
+					timer.Stop()
+				}
+				timer = time.AfterFunc(configDebounce, func() {
+					select {
+					case ch <- struct{}{}:
+					default:
+					}
+				})
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("warning: config watcher: %v", err)
+			}
+		}
+	}()
+	return ch
+}
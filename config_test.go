@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfigReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+	t.Cleanup(func() {
+		*query = "sun"
+		delete(configFlags, "q")
+	})
+	var steps = []struct {
+		config   string
+		want     string
+		inConfig bool
+	}{
+		{"q = nebula\n", "nebula", true},
+		{"# q removed\n", "sun", false},
+		{"q = galaxy\n", "galaxy", true},
+	}
+	for i, step := range steps {
+		if err := os.WriteFile(path, []byte(step.config), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := loadConfig(path); err != nil {
+			t.Fatalf("step %d: loadConfig: %v", i, err)
+		}
+		if *query != step.want {
+			t.Errorf("step %d: got -q %q, want %q", i, *query, step.want)
+		}
+		if flagSet("q") != step.inConfig {
+			t.Errorf("step %d: got flagSet(q) %v, want %v", i, flagSet("q"), step.inConfig)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+package main
+
+// curatedDates are APOD dates of exceptional images, picked from with
+// -curated; extend the list with each release
+var curatedDates = []string{
+	"1995-06-16", // the first APOD
+	"2000-11-27", // Earth at night
+	"2004-03-09", // Hubble Ultra Deep Field
+	"2006-10-16", // in the shadow of Saturn, Cassini
+	"2012-09-26", // Hubble eXtreme Deep Field
+	"2015-01-06", // the Pillars of Creation, Hubble revisit
+	"2015-07-14", // Pluto from New Horizons
+	"2018-12-24", // Earthrise, Apollo 8 anniversary
+	"2019-04-11", // first horizon-scale image of a black hole
+	"2020-02-13", // the Pale Blue Dot revisited
+	"2022-07-12", // Webb's first deep field
+	"2022-10-20", // the Pillars of Creation, Webb
+}
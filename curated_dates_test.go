@@ -0,0 +1,17 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCuratedDates(t *testing.T) {
+	for _, d := range curatedDates {
+		if _, err := parseAPODDate(d); err != nil {
+			t.Errorf("%s: %v", d, err)
+		}
+	}
+	if !slices.IsSorted(curatedDates) || len(slices.Compact(slices.Clone(curatedDates))) != len(curatedDates) {
+		t.Errorf("curated dates are not sorted and unique")
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// runDaemon sets a new wallpaper right away and then at every interval,
+// until the process is stopped; the config file is reloaded on SIGHUP and,
+// with -watch-config, whenever it changes
+func runDaemon(apiKey string, interval time.Duration) error {
+	if interval < time.Second {
+		return fmt.Errorf("interval too short: %s", interval)
+	}
+	ignoreBrokenPipe()
+	var (
+		ticker  = time.NewTicker(interval)
+		hup     = make(chan os.Signal, 1)
+		changed <-chan struct{}
+	)
+	defer ticker.Stop()
+	signal.Notify(hup, syscall.SIGHUP)
+	if *watchConfig {
+		changed = watchConfigFile(configFile())
+	}
+	for {
+		if reason := rotationBlocked(); reason != "" {
+			log.Printf("deferring rotation: %s", reason)
+		} else if err := rotate(apiKey, true); err != nil {
+			log.Printf("rotation failed: %v", err)
+		}
+	wait:
+		for {
+			select {
+			case <-ticker.C:
+				break wait
+			case <-hup:
+			case <-changed:
+			}
+			if err := reloadConfig(); err != nil {
+				log.Printf("failed to reload config: %v", err)
+				continue
+			}
+			log.Printf("reloaded config from %s", configFile())
+			apiKey = resolveAPIKey()
+			if *daemon >= time.Second && *daemon != interval {
+				interval = *daemon
+				ticker.Reset(interval)
+			}
+		}
+	}
+}
+
+// rotationBlocked returns a reason to skip a scheduled rotation, or an empty
+// string if the wallpaper may be changed
+func rotationBlocked() string {
+	if *skipFullscreen && fullscreenActive() {
+		return "fullscreen window active"
+	}
+	if *minIdle > 0 {
+		if idle, err := userIdle(); err == nil && idle < *minIdle {
+			return fmt.Sprintf("user idle for only %s", idle.Round(time.Second))
+		}
+	}
+	return ""
+}
+
+// fullscreenActive reports whether the active X11 window is fullscreen
+func fullscreenActive() bool {
+	if os.Getenv("DISPLAY") == "" {
+		return false
+	}
+	out, err := exec.Command("xprop", "-root", "_NET_ACTIVE_WINDOW").Output()
+	if err != nil {
+		return false
+	}
+	// _NET_ACTIVE_WINDOW(WINDOW): window id # 0x3a00007
+	fields := strings.Fields(string(out))
+	if len(fields) == 0 {
+		return false
+	}
+	id := strings.TrimSuffix(fields[len(fields)-1], ",")
+	if id == "0x0" {
+		return false
+	}
+	out, err = exec.Command("xprop", "-id", id, "_NET_WM_STATE").Output()
+	if err != nil {
+		return false
+	}
+	return strings.Contains(string(out), "_NET_WM_STATE_FULLSCREEN")
+}
+
+// userIdle returns the X11 idle time as reported by xprintidle
+func userIdle() (time.Duration, error) {
+	out, err := exec.Command("xprintidle").Output()
+	if err != nil {
+		return 0, err
+	}
+	ms, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
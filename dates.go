@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+	_ "time/tzdata"
+)
+
+// apodLocation is the time zone "today" is interpreted in, from -timezone
+var apodLocation = time.UTC
+
+// compileTimezone loads the -timezone location
+func compileTimezone() error {
+	if *timezone == "" || *timezone == "Local" {
+		apodLocation = time.Local
+		return nil
+	}
+	loc, err := time.LoadLocation(*timezone)
+	if err != nil {
+		return fmt.Errorf("invalid -timezone: %w", err)
+	}
+	apodLocation = loc
+	return nil
+}
+
+// apodNow returns the current time in the -timezone location
+func apodNow() time.Time {
+	return time.Now().In(apodLocation)
+}
+
+// apodFirstDay is the date of the first APOD
+var apodFirstDay = time.Date(1995, 6, 16, 0, 0, 0, 0, time.UTC)
+
+// fixedAPODDate reports whether -d, -today or -yesterday fix the APOD date,
+// so selecting again returns the same APOD
+func fixedAPODDate() bool {
+	return *apodDay != "" || *today || *yesterday
+}
+
+// calendarDays returns the number of calendar days from start to end, each
+// taken in its own location; unlike the duration, this is not thrown off by
+// days with a DST change
+func calendarDays(start, end time.Time) int {
+	var (
+		sy, sm, sd = start.Date()
+		ey, em, ed = end.Date()
+	)
+	days := time.Date(ey, em, ed, 0, 0, 0, 0, time.UTC).Sub(time.Date(sy, sm, sd, 0, 0, 0, 0, time.UTC)).Hours() / 24
+	return max(int(days), 0)
+}
+
+// parseAPODDate parses a YYYY-MM-DD date and checks that there can be an
+// APOD for it
+func parseAPODDate(s string) (time.Time, error) {
+	day, err := time.ParseInLocation("2006-01-02", s, apodLocation)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is not a YYYY-MM-DD date", s)
+	}
+	if day.Format("2006-01-02") < apodFirstDay.Format("2006-01-02") {
+		return time.Time{}, fmt.Errorf("date %s is before the first APOD on 1995-06-16", s)
+	}
+	if today := apodNow().Format("2006-01-02"); s > today {
+		return time.Time{}, fmt.Errorf("date %s is in the future, today is %s", s, today)
+	}
+	return day, nil
+}
+
+// selectAPODDate returns the image metadata of the APOD for a date
+func selectAPODDate(apiKey, dateStr string) (imageMeta, error) {
+	apod, err := loadAPOD(apiKey, dateStr)
+	if errors.Is(err, errNotPublished) {
+		return imageMeta{}, fmt.Errorf("no APOD for %s: %w", dateStr, err)
+	}
+	if err != nil {
+		return imageMeta{}, err
+	}
+	if !apodUsable(apod) {
+		return imageMeta{}, fmt.Errorf("APOD for %s is not an image (type: %s)", dateStr, apod.MediaType)
+	}
+	return apodMeta(apod), nil
+}
+
+// selectCuratedAPOD returns the image metadata of a random curated APOD
+func selectCuratedAPOD(apiKey string) (imageMeta, error) {
+	return selectAPODDate(apiKey, curatedDates[rand.Intn(len(curatedDates))])
+}
+
+// selectAPODDay returns the image metadata of the APOD for a given day; if
+// that has not been published yet, the day before is used instead
+func selectAPODDay(apiKey string, day time.Time) (imageMeta, error) {
+	dateStr := day.Format("2006-01-02")
+	apod, err := loadAPOD(apiKey, dateStr)
+	if errors.Is(err, errNotPublished) {
+		prev := day.AddDate(0, 0, -1).Format("2006-01-02")
+		log.Printf("warning: APOD for %s not published yet, using %s\n", dateStr, prev)
+		dateStr = prev
+		apod, err = loadAPOD(apiKey, dateStr)
+	}
+	if err != nil {
+		return imageMeta{}, err
+	}
+	if !apodUsable(apod) {
+		return imageMeta{}, fmt.Errorf("APOD for %s is not an image (type: %s)", dateStr, apod.MediaType)
+	}
+	return apodMeta(apod), nil
+}
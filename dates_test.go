@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRandomAPODDateDST(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(r int) { *apodRecent = r }(*apodRecent)
+	*apodRecent = 1
+	for _, end := range []time.Time{
+		time.Date(2024, 3, 10, 12, 0, 0, 0, ny), // spring forward, the day has 23 hours
+		time.Date(2024, 11, 3, 12, 0, 0, 0, ny), // fall back, the day has 25 hours
+	} {
+		var (
+			today     = end.Format("2006-01-02")
+			yesterday = end.AddDate(0, 0, -1).Format("2006-01-02")
+			got       = make(map[string]bool)
+		)
+		for range 200 {
+			got[randomAPODDateAt(end)] = true
+		}
+		if len(got) != 2 || !got[today] || !got[yesterday] {
+			t.Errorf("-apod-recent 1 at %s picked %v, want %s and %s", end, got, yesterday, today)
+		}
+	}
+}
+
+func TestCalendarDays(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		start, end time.Time
+		want       int
+	}{
+		{time.Date(2024, 3, 9, 12, 0, 0, 0, ny), time.Date(2024, 3, 10, 12, 0, 0, 0, ny), 1},
+		{time.Date(2024, 3, 9, 23, 0, 0, 0, ny), time.Date(2024, 3, 10, 1, 0, 0, 0, ny), 1},
+		{time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), 366},
+		{time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), 0},
+	}
+	for _, tt := range tests {
+		if got := calendarDays(tt.start, tt.end); got != tt.want {
+			t.Errorf("calendarDays(%s, %s) = %d, want %d", tt.start, tt.end, got, tt.want)
+		}
+	}
+}
+
+func TestWeightedDateDST(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testServer(t) // for the cache dir
+	defer func(r, n float64) { *weightRecency, *weightNovelty = r, n }(*weightRecency, *weightNovelty)
+	*weightRecency, *weightNovelty = 0, 5
+	var (
+		end       = time.Date(2024, 3, 10, 12, 0, 0, 0, ny)
+		start     = end.AddDate(0, 0, -1)
+		yesterday = start.Format("2006-01-02")
+	)
+	recordHistory(yesterday)
+	var today int
+	for range 100 {
+		if weightedDate(start, end).Format("2006-01-02") != yesterday {
+			today++
+		}
+	}
+	if today < 60 {
+		t.Errorf("picked today %d of 100 times, want it favored over %s in the history", today, yesterday)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// dedupImage moves a freshly downloaded image into a content addressed file
+// and replaces it with a link to that file, so identical images downloaded
+// from different URLs are stored only once
+func dedupImage(imagePath string, symlink bool) error {
+	sum, err := fileSHA256(imagePath)
+	if err != nil {
+		return err
+	}
+	canonical := filepath.Join(cacheDir, fmt.Sprintf("content_%x%s", sum[:16], filepath.Ext(imagePath)))
+	if _, err := os.Stat(canonical); os.IsNotExist(err) {
+		if err := os.Rename(imagePath, canonical); err != nil {
+			return err
+		}
+	} else if err := os.Remove(imagePath); err != nil {
+		return err
+	}
+	if symlink {
+		// relative target, so the cache directory can be moved
+		err = os.Symlink(filepath.Base(canonical), imagePath)
+	} else {
+		err = os.Link(canonical, imagePath)
+	}
+	if err != nil {
+		// keep a regular copy around, the cache must not lose the image
+		if cerr := copyFile(canonical, imagePath); cerr != nil {
+			return cerr
+		}
+		return fmt.Errorf("failed to link %s: %w", imagePath, err)
+	}
+	return nil
+}
+
+// contentTarget returns the content file an image is linked to, or an empty
+// string if the image was not deduplicated
+func contentTarget(imagePath string) (string, error) {
+	if dest, ok := symlinkTarget(imagePath); ok {
+		return dest, nil
+	}
+	fi, err := os.Stat(imagePath)
+	if err != nil {
+		return "", err
+	}
+	matches, err := filepath.Glob(filepath.Join(cacheDir, "content_*"))
+	if err != nil {
+		return "", err
+	}
+	for _, m := range matches {
+		if mi, err := os.Stat(m); err == nil && os.SameFile(fi, mi) {
+			return m, nil
+		}
+	}
+	return "", nil
+}
+
+// contentReferenced reports whether any cached image still refers to the
+// given content file, either by symlink or hardlink
+func contentReferenced(target string) (bool, error) {
+	images, err := cachedImages()
+	if err != nil {
+		return false, err
+	}
+	ti, err := os.Stat(target)
+	if err != nil {
+		return false, err
+	}
+	for _, imagePath := range images {
+		if dest, ok := symlinkTarget(imagePath); ok {
+			if dest == target {
+				return true, nil
+			}
+			continue
+		}
+		if fi, err := os.Stat(imagePath); err == nil && os.SameFile(fi, ti) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// symlinkTarget returns the resolved destination of a symlink
+func symlinkTarget(path string) (string, bool) {
+	dest, err := os.Readlink(path)
+	if err != nil {
+		return "", false
+	}
+	if !filepath.IsAbs(dest) {
+		dest = filepath.Join(filepath.Dir(path), dest)
+	}
+	return dest, true
+}
+
+// fileSHA256 returns the SHA-256 of a file's content
+func fileSHA256(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// copyFile copies the content of src to dst
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
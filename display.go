@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// displayPoll is how often -wait-for-display checks for a display
+const displayPoll = 2 * time.Second
+
+// displayAvailable reports whether the X11 or Wayland display named in the
+// environment has its socket in place; other systems always have a display
+func displayAvailable() bool {
+	if runtime.GOOS != "linux" {
+		return true
+	}
+	if name := os.Getenv("WAYLAND_DISPLAY"); name != "" {
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(os.Getenv("XDG_RUNTIME_DIR"), name)
+		}
+		if _, err := os.Stat(name); err == nil {
+			return true
+		}
+	}
+	if name := os.Getenv("DISPLAY"); name != "" {
+		host, num, _ := strings.Cut(name, ":")
+		if host != "" && host != "unix" {
+			return true
+		}
+		num, _, _ = strings.Cut(num, ".")
+		if _, err := os.Stat("/tmp/.X11-unix/X" + num); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// waitForDisplay runs f once a display is available, retrying failures until
+// the timeout passes; the last attempt is made regardless of the display, as
+// some setters do not need one
+func waitForDisplay(timeout time.Duration, f func() error) error {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if displayAvailable() {
+			err := f()
+			if err == nil {
+				return nil
+			}
+			verbosef("no display yet: %v", err)
+		} else {
+			verbosef("waiting for display")
+		}
+		time.Sleep(min(displayPoll, time.Until(deadline)))
+	}
+	if err := f(); err != nil {
+		return fmt.Errorf("no display after %s: %w", timeout, err)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// durationValue is a flag.Value for durations that, beyond what
+// time.ParseDuration accepts, allows days like 1d or 0.5d and plain numbers
+// of seconds
+type durationValue time.Duration
+
+// durationFlag defines a duration flag accepting the durationValue syntax
+func durationFlag(name string, value time.Duration, usage string) *time.Duration {
+	p := new(time.Duration)
+	*p = value
+	flag.Var((*durationValue)(p), name, usage)
+	return p
+}
+
+// parseDuration parses a duration in the durationValue syntax
+func parseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if secs, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(secs * float64(time.Second)), nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.ParseFloat(days, 64); err == nil {
+			return time.Duration(n * 24 * float64(time.Hour)), nil
+		}
+	}
+	return time.ParseDuration(s)
+}
+
+func (d *durationValue) Set(s string) error {
+	v, err := parseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = durationValue(v)
+	return nil
+}
+
+func (d *durationValue) String() string {
+	return (*time.Duration)(d).String()
+}
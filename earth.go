@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+const earthObservatoryURL = "https://earthobservatory.nasa.gov/feeds/image-of-the-day.rss"
+
+// RSSFeed represents an RSS 2.0 feed with enclosure and Media RSS elements
+type RSSFeed struct {
+	Channel struct {
+		Title string    `xml:"title"`
+		Items []RSSItem `xml:"item"`
+	} `xml:"channel"`
+}
+
+// RSSItem represents a single item in an RSS feed
+type RSSItem struct {
+	Title     string `xml:"title"`
+	Link      string `xml:"link"`
+	PubDate   string `xml:"pubDate"`
+	Enclosure struct {
+		URL  string `xml:"url,attr"`
+		Type string `xml:"type,attr"`
+	} `xml:"enclosure"`
+	MediaContent []struct {
+		URL    string `xml:"url,attr"`
+		Medium string `xml:"medium,attr"`
+		Type   string `xml:"type,attr"`
+		Width  int    `xml:"width,attr"`
+	} `xml:"http://search.yahoo.com/mrss/ content"`
+	MediaThumbnail struct {
+		URL string `xml:"url,attr"`
+	} `xml:"http://search.yahoo.com/mrss/ thumbnail"`
+}
+
+// imageURL returns the largest image referenced by an item, preferring
+// media:content over the enclosure
+func (item RSSItem) imageURL() string {
+	var (
+		best  string
+		width = -1
+	)
+	for _, c := range item.MediaContent {
+		if c.Medium != "image" && !strings.HasPrefix(c.Type, "image/") {
+			continue
+		}
+		if c.Width > width {
+			best, width = c.URL, c.Width
+		}
+	}
+	if best != "" {
+		return best
+	}
+	if strings.HasPrefix(item.Enclosure.Type, "image/") || item.Enclosure.Type == "" {
+		return item.Enclosure.URL
+	}
+	return ""
+}
+
+// parseRSS parses an RSS feed
+func parseRSS(data []byte) (*RSSFeed, error) {
+	var feed RSSFeed
+	if err := xml.Unmarshal(data, &feed); err != nil {
+		return nil, fmt.Errorf("failed to parse RSS: %w", err)
+	}
+	return &feed, nil
+}
+
+// fetchEarthObservatory fetches and displays the Earth Observatory image of
+// the day URL
+func fetchEarthObservatory(setWallpaper bool) error {
+	return fetchImage(selectEarthObservatory, setWallpaper)
+}
+
+// selectEarthObservatory returns the image metadata of the most recent Earth
+// Observatory image of the day
+func selectEarthObservatory() (imageMeta, error) {
+	res, err := fetchURL(earthObservatoryURL)
+	if err != nil {
+		return imageMeta{}, fmt.Errorf("failed to fetch feed: %w", err)
+	}
+	feed, err := parseRSS(res.Body)
+	if err != nil {
+		return imageMeta{}, err
+	}
+	for _, item := range feed.Channel.Items {
+		imageURL := item.imageURL()
+		if imageURL == "" {
+			continue
+		}
+		meta := imageMeta{
+			URL:       normalizeImageURL(imageURL),
+			Title:     item.Title,
+			Date:      item.PubDate,
+			MediaType: "image",
+		}
+		if item.MediaThumbnail.URL != "" {
+			meta.PreviewURL = normalizeImageURL(item.MediaThumbnail.URL)
+		}
+		return meta, nil
+	}
+	return imageMeta{}, fmt.Errorf("no image found in feed")
+}
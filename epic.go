@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"time"
+)
+
+const epicArchiveURL = "https://epic.gsfc.nasa.gov/archive/natural"
+
+// epicAPIURL is the EPIC API endpoint, a variable so tests can point it at a
+// local server
+var epicAPIURL = "https://api.nasa.gov/EPIC/api/natural/images"
+
+// EPICImage represents an image of the DSCOVR Earth Polychromatic Imaging
+// Camera, as returned by the EPIC API
+type EPICImage struct {
+	Identifier string `json:"identifier"`
+	Caption    string `json:"caption"`
+	Image      string `json:"image"`
+	Date       string `json:"date"`
+}
+
+// imageURL returns the archive URL of the full resolution PNG
+func (img EPICImage) imageURL() (string, error) {
+	t, err := time.Parse("2006-01-02 15:04:05", img.Date)
+	if err != nil {
+		return "", fmt.Errorf("invalid EPIC date: %w", err)
+	}
+	return fmt.Sprintf("%s/%s/png/%s.png", epicArchiveURL, t.Format("2006/01/02"), img.Image), nil
+}
+
+// fetchEPIC fetches and displays a random image URL from the most recent
+// day of EPIC images
+func fetchEPIC(apiKey string, setWallpaper bool) error {
+	return fetchImage(func() (imageMeta, error) { return selectEPIC(apiKey) }, setWallpaper)
+}
+
+// selectEPIC picks a random image of the whole Earth from the most recent
+// day of EPIC images; rate limited keys are switched for -key-pool keys
+func selectEPIC(apiKey string) (imageMeta, error) {
+	var images []EPICImage
+	for {
+		key := activeKey(apiKey)
+		v := url.Values{}
+		v.Set("api_key", key)
+		err := getJSON(epicAPIURL+"?"+v.Encode(), &images)
+		if errors.Is(err, errRateLimited) && activeKey(apiKey) != key {
+			continue
+		}
+		if err != nil {
+			return imageMeta{}, fmt.Errorf("failed to fetch EPIC images: %w", err)
+		}
+		break
+	}
+	if len(images) == 0 {
+		return imageMeta{}, fmt.Errorf("no EPIC images available")
+	}
+	img := images[rand.Intn(len(images))]
+	imageURL, err := img.imageURL()
+	if err != nil {
+		return imageMeta{}, err
+	}
+	return imageMeta{
+		URL:       imageURL,
+		Title:     img.Caption,
+		Date:      img.Date[:min(len(img.Date), 10)],
+		MediaType: "image",
+	}, nil
+}
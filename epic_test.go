@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSelectEPICKeyPool(t *testing.T) {
+	testServer(t) // for the HTTP client and cache dir
+	var keys []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.FormValue("api_key")
+		keys = append(keys, key)
+		if key == "primary" {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[{"identifier":"20240110003633","caption":"Earth","image":"epic_1b_20240110003633","date":"2024-01-10 00:31:45"}]`))
+	}))
+	defer srv.Close()
+	defer func(u, pool string, r int) { epicAPIURL, *keyPool, *retries = u, pool, r }(epicAPIURL, *keyPool, *retries)
+	epicAPIURL, *keyPool, *retries = srv.URL, "pooled", 0
+	meta, err := selectEPIC("primary")
+	if err != nil {
+		t.Fatalf("selectEPIC: %v", err)
+	}
+	if len(keys) != 2 || keys[1] != "pooled" {
+		t.Errorf("got requests with keys %v, want primary then pooled", keys)
+	}
+	if want := epicArchiveURL + "/2024/01/10/png/epic_1b_20240110003633.png"; meta.URL != want {
+		t.Errorf("got URL %s, want %s", meta.URL, want)
+	}
+	if _, err := selectEPIC("primary"); err != nil || len(keys) != 3 || keys[2] != "pooled" {
+		t.Errorf("rate limited primary key was used again: %v, keys %v", err, keys)
+	}
+}
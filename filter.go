@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// imageFilters are the per-pixel transforms available with -filter, working
+// on 8 bit channel values
+var imageFilters = map[string]func(r, g, b float64) (float64, float64, float64){
+	"grayscale": func(r, g, b float64) (float64, float64, float64) {
+		y := 0.299*r + 0.587*g + 0.114*b
+		return y, y, y
+	},
+	"sepia": func(r, g, b float64) (float64, float64, float64) {
+		return 0.393*r + 0.769*g + 0.189*b,
+			0.349*r + 0.686*g + 0.168*b,
+			0.272*r + 0.534*g + 0.131*b
+	},
+	"invert": func(r, g, b float64) (float64, float64, float64) {
+		return 255 - r, 255 - g, 255 - b
+	},
+}
+
+// clamp8 rounds v to the nearest value between 0 and 255
+func clamp8(v float64) uint8 {
+	switch {
+	case v <= 0:
+		return 0
+	case v >= 255:
+		return 255
+	default:
+		return uint8(v + 0.5)
+	}
+}
+
+// filterImage applies a -filter transform to a copy of an image and returns
+// the path of the copy; filtered variants are cached by filter name
+func filterImage(imagePath, name string) (string, error) {
+	var (
+		base    = strings.TrimSuffix(filepath.Base(imagePath), filepath.Ext(imagePath))
+		outPath = filepath.Join(cacheDir, fmt.Sprintf("filter_%s_%s.jpg", name, base))
+	)
+	if _, err := os.Stat(outPath); err == nil {
+		return outPath, nil
+	}
+	transform, ok := imageFilters[name]
+	if !ok {
+		return "", fmt.Errorf("unknown filter: %s", name)
+	}
+	f, err := os.Open(imagePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	src, _, err := image.Decode(f)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode image: %w", err)
+	}
+	var (
+		bounds = src.Bounds()
+		dst    = image.NewRGBA(bounds)
+	)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.RGBAModel.Convert(src.At(x, y)).(color.RGBA)
+			r, g, b := transform(float64(c.R), float64(c.G), float64(c.B))
+			dst.SetRGBA(x, y, color.RGBA{clamp8(r), clamp8(g), clamp8(b), c.A})
+		}
+	}
+	out, err := os.Create(outPath)
+	if err != nil {
+		return "", err
+	}
+	if err := jpeg.Encode(out, dst, &jpeg.Options{Quality: 92}); err != nil {
+		out.Close()
+		os.Remove(outPath)
+		return "", err
+	}
+	return outPath, out.Close()
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	flickrFeedURL = "https://www.flickr.com/services/feeds/photos_public.gne"
+	spacexUserID  = "130608600@N05"
+)
+
+// flickrLarge is the largest Flickr size suffix that shares the secret of
+// the medium size URLs in feeds; the _h and _k sizes have their own secrets
+// and can only be found with the API
+const flickrLarge = "_b"
+
+// FlickrFeed represents the JSON variant of a Flickr public photo feed
+type FlickrFeed struct {
+	Title string       `json:"title"`
+	Items []FlickrItem `json:"items"`
+}
+
+// FlickrItem represents a single photo in a Flickr feed
+type FlickrItem struct {
+	Title string `json:"title"`
+	Link  string `json:"link"`
+	Media struct {
+		M string `json:"m"`
+	} `json:"media"`
+	DateTaken string `json:"date_taken"`
+	Published string `json:"published"`
+}
+
+// fetchFlickrFeed fetches the public photo feed of a Flickr user
+func fetchFlickrFeed(userID string) (*FlickrFeed, error) {
+	v := url.Values{}
+	v.Set("id", userID)
+	v.Set("format", "json")
+	v.Set("nojsoncallback", "1")
+	var feed FlickrFeed
+	if err := getJSON(flickrFeedURL+"?"+v.Encode(), &feed); err != nil {
+		return nil, err
+	}
+	return &feed, nil
+}
+
+// largestFlickrURL returns the URL of the large size of a photo, given the
+// URL of its medium size, if the large size exists; a redirect means it does
+// not, Flickr then redirects to a "photo unavailable" placeholder
+func largestFlickrURL(mediumURL string) string {
+	if !strings.Contains(mediumURL, "_m.") {
+		return mediumURL
+	}
+	var (
+		candidate = strings.Replace(mediumURL, "_m.", flickrLarge+".", 1)
+		client    = *httpClient
+	)
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	resp, err := client.Head(candidate)
+	if err != nil {
+		return mediumURL
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "image/") {
+		return mediumURL
+	}
+	return candidate
+}
+
+// fetchSpaceX fetches and displays a random recent SpaceX photo URL
+func fetchSpaceX(setWallpaper bool) error {
+	return fetchImage(selectSpaceX, setWallpaper)
+}
+
+// selectSpaceX picks a random recent photo from the SpaceX photostream and
+// returns its image metadata
+func selectSpaceX() (imageMeta, error) {
+	feed, err := fetchFlickrFeed(spacexUserID)
+	if err != nil {
+		return imageMeta{}, fmt.Errorf("failed to fetch photostream: %w", err)
+	}
+	var items []FlickrItem
+	for _, item := range feed.Items {
+		if item.Media.M != "" && titleAccepted(item.Title) {
+			items = append(items, item)
+		}
+	}
+	if len(items) == 0 {
+		return imageMeta{}, fmt.Errorf("no photos in photostream")
+	}
+	item := items[rand.Intn(len(items))]
+	return imageMeta{
+		URL:        largestFlickrURL(normalizeImageURL(item.Media.M)),
+		PreviewURL: normalizeImageURL(item.Media.M),
+		Title:      item.Title,
+		Date:       item.DateTaken,
+		MediaType:  "image",
+	}, nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLargestFlickrURL(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/1_abc_b.jpg":
+			w.Header().Set("Content-Type", "image/jpeg")
+		case "/2_abc_b.jpg":
+			http.Redirect(w, r, "/photo_unavailable.png", http.StatusFound)
+		case "/photo_unavailable.png":
+			w.Header().Set("Content-Type", "image/png")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	defer func(c *http.Client) { httpClient = c }(httpClient)
+	httpClient = srv.Client()
+	var tests = []struct {
+		medium, want string
+	}{
+		{srv.URL + "/1_abc_m.jpg", srv.URL + "/1_abc_b.jpg"},
+		{srv.URL + "/2_abc_m.jpg", srv.URL + "/2_abc_m.jpg"},
+		{srv.URL + "/3_abc_m.jpg", srv.URL + "/3_abc_m.jpg"},
+		{srv.URL + "/4_abc.jpg", srv.URL + "/4_abc.jpg"},
+	}
+	for _, tt := range tests {
+		if got := largestFlickrURL(tt.medium); got != tt.want {
+			t.Errorf("largestFlickrURL(%q) = %q, want %q", tt.medium, got, tt.want)
+		}
+	}
+}
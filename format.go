@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"os/exec"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// cacheFormatExt maps the supported -cache-format values to file extensions
+var cacheFormatExt = map[string]string{
+	"jpeg": ".jpg",
+	"png":  ".png",
+	"webp": ".webp",
+}
+
+// convertImage re-encodes the image at path in the given format, replacing
+// the file; images already in that format are left alone; go has no webp
+// encoder, so webp goes through cwebp
+func convertImage(path, format string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	src, srcFormat, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("failed to decode image: %w", err)
+	}
+	if srcFormat == format {
+		return nil
+	}
+	tmp := path + ".tmp"
+	defer os.Remove(tmp)
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(out, src, &jpeg.Options{Quality: 92})
+	case "png", "webp":
+		err = png.Encode(out, src)
+	default:
+		err = fmt.Errorf("unsupported cache format: %s", format)
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encode image: %w", err)
+	}
+	if format == "webp" {
+		if out, err := exec.Command("cwebp", "-quiet", "-q", "90", tmp, "-o", path).CombinedOutput(); err != nil {
+			return fmt.Errorf("cwebp failed: %v: %s", err, out)
+		}
+		return nil
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"slices"
+	"strings"
+)
+
+// genreKeywords maps APOD genres to keywords found in titles and explanations
+var genreKeywords = map[string][]string{
+	"nebula":       {"nebula", "nebulae", "planetary nebula", "supernova remnant", "molecular cloud", "emission", "pillars"},
+	"galaxy":       {"galaxy", "galaxies", "andromeda", "spiral arm", "milky way", "magellanic", "quasar"},
+	"planet":       {"planet", "jupiter", "saturn", "mars", "venus", "mercury", "neptune", "uranus", "pluto", "moon"},
+	"star cluster": {"star cluster", "globular", "open cluster", "pleiades", "hyades"},
+	"comet":        {"comet", "coma", "ion tail", "dust tail"},
+	"aurora":       {"aurora", "auroral", "northern lights", "southern lights"},
+	"earth":        {"earth", "horizon", "landscape", "sunset", "sunrise", "eclipse", "lightning"},
+	"rover":        {"rover", "curiosity", "perseverance", "opportunity", "spirit", "sojourner"},
+	"spacecraft":   {"spacecraft", "space station", "shuttle", "probe", "satellite", "launch", "rocket"},
+}
+
+// genres returns the known genres in a stable order
+func genres() []string {
+	var names []string
+	for name := range genreKeywords {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// classifyAPOD returns the genre whose keywords occur most often in the
+// title and explanation of an APOD, title matches weigh more; returns an
+// empty string if no keyword matches
+func classifyAPOD(apod APOD) string {
+	var (
+		title       = strings.ToLower(apod.Title)
+		explanation = strings.ToLower(apod.Explanation)
+		best        string
+		bestScore   int
+	)
+	for _, genre := range genres() {
+		score := 0
+		for _, kw := range genreKeywords[genre] {
+			score += 3*strings.Count(title, kw) + strings.Count(explanation, kw)
+		}
+		if score > bestScore {
+			best, bestScore = genre, score
+		}
+	}
+	return best
+}
+
+// genreAccepted reports whether an APOD matches one of the genres requested
+// with -genre, or preferred by -theme
+func genreAccepted(apod APOD) bool {
+	wanted := requestedGenres()
+	if wanted == "" {
+		return true
+	}
+	genre := classifyAPOD(apod)
+	for _, g := range strings.Split(wanted, ",") {
+		if strings.TrimSpace(strings.ToLower(g)) == genre {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	githubAPIURL = "https://api.github.com"
+	githubRawURL = "https://raw.githubusercontent.com"
+	githubOrg    = "nasa"
+	// githubMinSize skips icons and other small images in repositories
+	githubMinSize = 100 << 10
+)
+
+// GitHubRepo represents a repository in the GitHub API
+type GitHubRepo struct {
+	Name          string `json:"name"`
+	DefaultBranch string `json:"default_branch"`
+	Size          int    `json:"size"`
+}
+
+// GitHubTree represents a recursive git tree listing in the GitHub API
+type GitHubTree struct {
+	Tree []struct {
+		Path string `json:"path"`
+		Type string `json:"type"`
+		Size int64  `json:"size"`
+	} `json:"tree"`
+	Truncated bool `json:"truncated"`
+}
+
+// getGitHubJSON fetches a GitHub API endpoint and decodes the JSON response
+// into v; GITHUB_TOKEN is used if set, since anonymous requests are limited
+// to 60 per hour
+func getGitHubJSON(endpoint string, v any) error {
+	req, err := http.NewRequest("GET", githubAPIURL+endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if token := os.Getenv("GITHUB_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	start := time.Now()
+	resp, err := doWithRetry(httpClient, req)
+	bench.observeAPI(start)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusForbidden, http.StatusTooManyRequests:
+		return errRateLimited
+	default:
+		return fmt.Errorf("GitHub API returned status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	return parseJSON(body, resp.Header.Get("Content-Type"), v)
+}
+
+// githubImages returns the paths of large images in a repository
+func githubImages(repo GitHubRepo) ([]string, error) {
+	var tree GitHubTree
+	endpoint := fmt.Sprintf("/repos/%s/%s/git/trees/%s?recursive=1",
+		githubOrg, url.PathEscape(repo.Name), url.PathEscape(repo.DefaultBranch))
+	if err := getGitHubJSON(endpoint, &tree); err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, entry := range tree.Tree {
+		if entry.Type != "blob" || entry.Size < githubMinSize {
+			continue
+		}
+		switch strings.ToLower(path.Ext(entry.Path)) {
+		case ".jpg", ".jpeg", ".png":
+			paths = append(paths, entry.Path)
+		}
+	}
+	return paths, nil
+}
+
+// fetchNASAGitHub fetches and displays a random image URL from a NASA
+// GitHub repository
+func fetchNASAGitHub(setWallpaper bool) error {
+	return fetchImage(selectNASAGitHub, setWallpaper)
+}
+
+// selectNASAGitHub picks random repositories of the NASA GitHub organization
+// until one contains large images and returns a random one of them
+func selectNASAGitHub() (imageMeta, error) {
+	var repos []GitHubRepo
+	endpoint := fmt.Sprintf("/orgs/%s/repos?per_page=100&sort=pushed", githubOrg)
+	if err := getGitHubJSON(endpoint, &repos); err != nil {
+		return imageMeta{}, fmt.Errorf("failed to list repositories: %w", err)
+	}
+	repos = slices.DeleteFunc(repos, func(r GitHubRepo) bool { return r.Size == 0 })
+	rand.Shuffle(len(repos), func(i, j int) { repos[i], repos[j] = repos[j], repos[i] })
+	for i, repo := range repos {
+		if i > maxRerolls {
+			break
+		}
+		paths, err := githubImages(repo)
+		if err != nil {
+			return imageMeta{}, fmt.Errorf("failed to list %s: %w", repo.Name, err)
+		}
+		if len(paths) == 0 {
+			verbosef("no images in %s/%s", githubOrg, repo.Name)
+			continue
+		}
+		p := paths[rand.Intn(len(paths))]
+		return imageMeta{
+			URL:       fmt.Sprintf("%s/%s/%s/%s/%s", githubRawURL, githubOrg, repo.Name, repo.DefaultBranch, p),
+			Title:     fmt.Sprintf("%s: %s", repo.Name, path.Base(p)),
+			MediaType: "image",
+		}, nil
+	}
+	return imageMeta{}, fmt.Errorf("no images found in %s repositories", githubOrg)
+}
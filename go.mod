@@ -2,6 +2,12 @@ module github.com/miku/apodwall
 
 go 1.25.2
 
-require github.com/adrg/xdg v0.5.3
+require (
+	github.com/adrg/xdg v0.5.3
+	github.com/fsnotify/fsnotify v1.9.0
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
+	golang.org/x/image v0.33.0
+	golang.org/x/sync v0.17.0
+)
 
 require golang.org/x/sys v0.26.0 // indirect
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+const (
+	greeterImageDir = "/usr/share/backgrounds/apodwall"
+	lightdmGtkConf  = "/etc/lightdm/lightdm-gtk-greeter.conf"
+	sddmConf        = "/etc/sddm.conf"
+	sddmThemesDir   = "/usr/share/sddm/themes"
+)
+
+// setGreeterBackground copies an image to a system location and points the
+// installed login manager at it; this needs root privileges
+func setGreeterBackground(imagePath string) error {
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("greeter background is only supported on linux")
+	}
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("setting the greeter background requires root privileges, run with sudo")
+	}
+	if err := os.MkdirAll(greeterImageDir, 0755); err != nil {
+		return err
+	}
+	dst := filepath.Join(greeterImageDir, "background"+filepath.Ext(imagePath))
+	if err := copyFile(imagePath, dst); err != nil {
+		return fmt.Errorf("failed to copy image: %w", err)
+	}
+	if err := os.Chmod(dst, 0644); err != nil {
+		return err
+	}
+	var found bool
+	if _, err := os.Stat(filepath.Dir(lightdmGtkConf)); err == nil {
+		found = true
+		if err := setIniValue(lightdmGtkConf, "greeter", "background", dst); err != nil {
+			return fmt.Errorf("failed to configure LightDM: %w", err)
+		}
+	}
+	if _, err := exec.LookPath("sddm"); err == nil {
+		found = true
+		conf := filepath.Join(sddmThemesDir, sddmTheme(), "theme.conf.user")
+		if err := setIniValue(conf, "General", "background", dst); err != nil {
+			return fmt.Errorf("failed to configure SDDM: %w", err)
+		}
+	}
+	if !found && gdmBinary() != "" {
+		// GDM draws the login screen from the GNOME Shell theme resource, the
+		// gsettings of the gdm user are not used for it
+		return fmt.Errorf("GDM is not supported, its background is part of the GNOME Shell theme")
+	}
+	if !found {
+		return fmt.Errorf("no supported login manager found (LightDM, SDDM)")
+	}
+	return nil
+}
+
+// sddmTheme returns the configured SDDM theme, breeze if not configured
+func sddmTheme() string {
+	f, err := os.Open(sddmConf)
+	if err != nil {
+		return "breeze"
+	}
+	defer f.Close()
+	var (
+		section string
+		scanner = bufio.NewScanner(f)
+	)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			section = strings.Trim(line, "[]")
+			continue
+		}
+		if k, v, ok := strings.Cut(line, "="); ok && section == "Theme" && strings.TrimSpace(k) == "Current" {
+			return strings.TrimSpace(v)
+		}
+	}
+	return "breeze"
+}
+
+// gdmBinary returns the path of the GDM daemon, if installed
+func gdmBinary() string {
+	for _, name := range []string{"gdm3", "gdm"} {
+		if p, err := exec.LookPath(name); err == nil {
+			return p
+		}
+	}
+	return ""
+}
+
+// setIniValue sets key in section of an ini style file, adding the section
+// or the key if necessary; a missing file is created
+func setIniValue(path, section, key, value string) error {
+	b, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var (
+		lines   = strings.Split(strings.TrimRight(string(b), "\n"), "\n")
+		out     []string
+		current string
+		done    bool
+		entry   = key + "=" + value
+	)
+	if len(b) == 0 {
+		lines = nil
+	}
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") {
+			if current == section && !done {
+				out = append(out, entry)
+				done = true
+			}
+			current = strings.Trim(trimmed, "[]")
+		} else if k, _, ok := strings.Cut(trimmed, "="); ok && current == section && strings.TrimSpace(k) == key {
+			if !done {
+				out = append(out, entry)
+				done = true
+			}
+			continue
+		}
+		out = append(out, line)
+	}
+	if !done {
+		if current != section {
+			out = append(out, "["+section+"]")
+		}
+		out = append(out, entry)
+	}
+	return os.WriteFile(path, []byte(strings.Join(out, "\n")+"\n"), 0644)
+}
+
+// applyGreeter sets the greeter background if requested, failures are only
+// reported, since the desktop wallpaper has already been set
+func applyGreeter(imagePath string) {
+	if !*setGreeter {
+		return
+	}
+	if err := setGreeterBackground(imagePath); err != nil {
+		log.Printf("warning: failed to set greeter background: %v\n", err)
+	}
+}
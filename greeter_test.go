@@ -0,0 +1,36 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSetIniValue(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"missing file", "", "[greeter]\nbackground=/bg.jpg\n"},
+		{"replace key", "[greeter]\ntheme-name=Adwaita\nbackground=/old.jpg\n", "[greeter]\ntheme-name=Adwaita\nbackground=/bg.jpg\n"},
+		{"add key to section", "[greeter]\ntheme-name=Adwaita\n[other]\nbackground=/keep.jpg\n", "[greeter]\ntheme-name=Adwaita\nbackground=/bg.jpg\n[other]\nbackground=/keep.jpg\n"},
+		{"add section", "[other]\nx=1\n", "[other]\nx=1\n[greeter]\nbackground=/bg.jpg\n"},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "greeter.conf")
+		if tt.in != "" {
+			if err := os.WriteFile(path, []byte(tt.in), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := setIniValue(path, "greeter", "background", "/bg.jpg"); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, b, tt.want)
+		}
+	}
+}
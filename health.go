@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// healthTimeout limits each health check request
+const healthTimeout = 10 * time.Second
+
+// healthURL returns the URL checked for a source; sources serving cutouts
+// from query parameters are checked by their site
+func healthURL(name, apiKey string) string {
+	name, arg, _ := strings.Cut(name, ":")
+	switch name {
+	case "apod":
+		return apodRequestURL(apiKey, apodNow().AddDate(0, 0, -1).Format("2006-01-02"))
+	case "nasa":
+		if arg == "" {
+			arg = searchQuery()
+		}
+		return nasaImagesURL + "?" + neturl.Values{"media_type": {"image"}, "q": {arg}}.Encode()
+	case "jpl":
+		return jplFeaturedURL
+	case "earth":
+		return earthObservatoryURL
+	case "spacex":
+		return flickrFeedURL
+	case "legacysurvey":
+		return "https://www.legacysurvey.org/"
+	case "github":
+		return githubAPIURL
+	case "epic":
+		return epicAPIURL + "?" + neturl.Values{"api_key": {apiKey}}.Encode()
+	case "panstarrs":
+		return "https://ps1images.stsci.edu/"
+	case "hubblesite":
+		return hubblesiteURL
+	case "apod-search":
+		return apodSearchURL + "?" + neturl.Values{"q": {"galaxy"}}.Encode()
+	}
+	return ""
+}
+
+// configuredSources returns the sources used with the current flags: the
+// -sources list or the selected source, APOD by default, and the
+// -fallback-source
+func configuredSources() []string {
+	var names []string
+	switch {
+	case len(sourceOrder) > 0:
+		names = append(names, sourceOrder...)
+	case *nasaFlag:
+		names = append(names, "nasa")
+	case *jplFlag:
+		names = append(names, "jpl")
+	case *earthFlag:
+		names = append(names, "earth")
+	case *spacexFlag:
+		names = append(names, "spacex")
+	case *legacySurvey:
+		names = append(names, "legacysurvey")
+	case *nasaGitHub:
+		names = append(names, "github")
+	case *epicFlag:
+		names = append(names, "epic")
+	case *panstarrs:
+		names = append(names, "panstarrs")
+	case *hubblesite:
+		names = append(names, "hubblesite")
+	case *apodSearch != "":
+		names = append(names, "apod-search")
+	case *imageListFile != "":
+	default:
+		names = append(names, "apod")
+	}
+	if *fallbackSource != "" {
+		names = append(names, *fallbackSource)
+	}
+	return names
+}
+
+// checkSource sends a HEAD request, or a GET if the server does not allow
+// HEAD, and describes the outcome; for the NASA API, a rate limited or
+// invalid key fails the check
+func checkSource(client *http.Client, url string) (ok bool, detail string) {
+	resp, err := client.Head(url)
+	if err == nil && (resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented) {
+		resp.Body.Close()
+		resp, err = client.Get(url)
+	}
+	if ue, ok := err.(*neturl.Error); ok {
+		// the URL may carry the API key
+		err = ue.Err
+	}
+	if err != nil {
+		return false, err.Error()
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	detail = resp.Status
+	if remaining := resp.Header.Get("X-RateLimit-Remaining"); remaining != "" {
+		detail += fmt.Sprintf(", %s requests left", remaining)
+		if remaining == "0" {
+			return false, detail + ", rate limited"
+		}
+	}
+	switch {
+	case resp.StatusCode == http.StatusForbidden && strings.HasPrefix(url, apodURL):
+		return false, detail + ", API key invalid"
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return false, detail + ", rate limited"
+	}
+	return resp.StatusCode/100 == 2, detail
+}
+
+// runHealthCheck checks that every configured source is reachable, prints
+// a status table and returns an error if any check failed
+func runHealthCheck(apiKey string) error {
+	client := *httpClient
+	client.Timeout = healthTimeout
+	var (
+		tw     = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		failed int
+	)
+	fmt.Fprintln(tw, "SOURCE\tSTATUS\tDETAIL")
+	for _, name := range configuredSources() {
+		url := healthURL(name, apiKey)
+		if url == "" {
+			continue
+		}
+		status := "ok"
+		ok, detail := checkSource(&client, url)
+		if !ok {
+			status = "FAIL"
+			failed++
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", name, status, detail)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d source(s) failed", failed)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	neturl "net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// heicBrands are the ISO base media file format brands used by HEIC and
+// HEIF images
+var heicBrands = []string{"heic", "heix", "hevc", "hevx", "mif1", "msf1"}
+
+// isHEIC reports whether the file at path is a HEIC or HEIF image, by the
+// ftyp box at its start
+func isHEIC(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return false
+	}
+	if !bytes.Equal(header[4:8], []byte("ftyp")) {
+		return false
+	}
+	for _, brand := range heicBrands {
+		if string(header[8:12]) == brand {
+			return true
+		}
+	}
+	return false
+}
+
+// heicURL reports whether an image URL names a HEIC or HEIF file
+func heicURL(imageURL string) bool {
+	u, err := neturl.Parse(imageURL)
+	if err != nil {
+		return false
+	}
+	ext := strings.ToLower(filepath.Ext(u.Path))
+	return ext == ".heic" || ext == ".heif"
+}
+
+// heicConvertible reports whether convertHEIC works on this system
+func heicConvertible() bool {
+	return runtime.GOOS == "darwin"
+}
+
+// convertHEIC replaces a HEIC image with a JPEG version, which is only
+// possible on macOS, using sips; elsewhere the image is skipped with
+// errSkipImage
+func convertHEIC(path string) error {
+	if !heicConvertible() {
+		return fmt.Errorf("%w: converting HEIC requires sips on macOS", errSkipImage)
+	}
+	tmp := path + ".tmp"
+	defer os.Remove(tmp)
+	if out, err := exec.Command("sips", "-s", "format", "jpeg", path, "--out", tmp).CombinedOutput(); err != nil {
+		return fmt.Errorf("sips failed: %v: %s", err, out)
+	}
+	return os.Rename(tmp, path)
+}
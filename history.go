@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// historyFile lists the APOD dates shown most recently, oldest first
+const historyFile = "history.json"
+
+// historyPath returns the location of the history file
+func historyPath() string {
+	return filepath.Join(cacheDir, historyFile)
+}
+
+// loadHistory reads the dates shown recently; a missing or broken history
+// is empty
+func loadHistory() []string {
+	var dates []string
+	b, err := os.ReadFile(historyPath())
+	if err != nil {
+		return nil
+	}
+	if err := json.Unmarshal(b, &dates); err != nil {
+		log.Printf("warning: ignoring broken history: %v\n", err)
+		return nil
+	}
+	return dates
+}
+
+// recordHistory adds a shown date to the history, keeping the last
+// -history-size entries
+func recordHistory(date string) {
+	if *historySize <= 0 {
+		return
+	}
+	history := slices.DeleteFunc(loadHistory(), func(d string) bool { return d == date })
+	history = append(history, date)
+	if len(history) > *historySize {
+		history = history[len(history)-*historySize:]
+	}
+	b, err := json.Marshal(history)
+	if err != nil {
+		log.Printf("warning: failed to encode history: %v\n", err)
+		return
+	}
+	if err := os.WriteFile(historyPath(), b, 0644); err != nil {
+		log.Printf("warning: failed to write history: %v\n", err)
+	}
+}
+
+// clearHistory removes the history file
+func clearHistory() error {
+	if err := os.Remove(historyPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
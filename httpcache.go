@@ -0,0 +1,79 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// cachedHTTPGet fetches a URL through a response cache in dir: a cached
+// body younger than ttl is returned without a request, an older one is
+// revalidated by its ETag and returned on 304; on 200 the body and ETag are
+// stored for next time
+func cachedHTTPGet(client *http.Client, url, dir string, ttl time.Duration) ([]byte, error) {
+	var (
+		hash     = sha256.Sum256([]byte(url))
+		bodyPath = filepath.Join(dir, fmt.Sprintf("http_%x.body", hash[:8]))
+		etagPath = bodyPath + ".etag"
+	)
+	if fi, err := os.Stat(bodyPath); err == nil && time.Since(fi.ModTime()) < ttl {
+		if body, err := os.ReadFile(bodyPath); err == nil {
+			bench.observeCache(true)
+			return body, nil
+		}
+	}
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if etag, err := os.ReadFile(etagPath); err == nil {
+		if _, err := os.Stat(bodyPath); err == nil {
+			req.Header.Set("If-None-Match", strings.TrimSpace(string(etag)))
+		}
+	}
+	start := time.Now()
+	resp, err := doWithRetry(client, req)
+	bench.observeAPI(start)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		bench.observeCache(true)
+		verbosef("not modified: %s", url)
+		now := time.Now()
+		os.Chtimes(bodyPath, now, now)
+		return os.ReadFile(bodyPath)
+	case http.StatusOK:
+	case http.StatusTooManyRequests:
+		return nil, errRateLimited
+	default:
+		return nil, fmt.Errorf("API returned status %d", resp.StatusCode)
+	}
+	bench.observeCache(false)
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	etag := resp.Header.Get("ETag")
+	if etag == "" && ttl <= 0 {
+		return body, nil
+	}
+	if err := os.WriteFile(bodyPath, body, 0644); err != nil {
+		log.Printf("warning: failed to cache response: %v\n", err)
+		return body, nil
+	}
+	if etag == "" {
+		os.Remove(etagPath)
+	} else if err := os.WriteFile(etagPath, []byte(etag), 0644); err != nil {
+		log.Printf("warning: failed to cache response: %v\n", err)
+	}
+	return body, nil
+}
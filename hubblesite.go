@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+)
+
+const hubblesiteURL = "https://hubblesite.org/api/v3/news_release?page=1&per_page=100"
+
+// HubblesiteRelease represents a news release from the HubbleSite API
+type HubblesiteRelease struct {
+	Name            string `json:"name"`
+	NewsID          string `json:"news_id"`
+	URL             string `json:"url"`
+	Publication     string `json:"publication"`
+	KeystoneImage1x string `json:"keystone_image_1x"`
+	KeystoneImage2x string `json:"keystone_image_2x"`
+}
+
+// fetchHubblesite fetches and displays the image URL of a random HubbleSite
+// news release
+func fetchHubblesite(setWallpaper bool) error {
+	return fetchImage(selectHubblesite, setWallpaper)
+}
+
+// selectHubblesite picks a random HubbleSite news release with a high
+// resolution keystone image and returns its image metadata
+func selectHubblesite() (imageMeta, error) {
+	var releases []HubblesiteRelease
+	if err := getJSON(hubblesiteURL, &releases); err != nil {
+		return imageMeta{}, fmt.Errorf("failed to fetch news releases: %w", err)
+	}
+	releases = slices.DeleteFunc(releases, func(r HubblesiteRelease) bool {
+		return r.KeystoneImage2x == "" || !titleAccepted(r.Name)
+	})
+	if len(releases) == 0 {
+		return imageMeta{}, fmt.Errorf("no news releases with images")
+	}
+	r := releases[rand.Intn(len(releases))]
+	meta := imageMeta{
+		URL:       normalizeImageURL(r.KeystoneImage2x),
+		Title:     r.Name,
+		Date:      r.Publication[:min(len(r.Publication), 10)],
+		MediaType: "image",
+	}
+	if r.KeystoneImage1x != "" {
+		meta.PreviewURL = normalizeImageURL(r.KeystoneImage1x)
+	}
+	verbosef("%s", r.Name)
+	return meta, nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/adrg/xdg"
+)
+
+// lockImagePath returns where -i3lock-image keeps the lock screen image;
+// i3lock only reads PNG
+func lockImagePath() string {
+	return filepath.Join(xdg.ConfigHome, cacheSubdir, "lock.png")
+}
+
+// i3lockConfig returns an i3 config fragment that locks the screen with the
+// current wallpaper
+func i3lockConfig() string {
+	return fmt.Sprintf("# lock the screen with the current apodwall wallpaper\nbindsym $mod+l exec i3lock -i %s\n", shellQuote(lockImagePath()))
+}
+
+// applyLockImage copies the wallpaper as PNG to the lock image location, if
+// -i3lock-image is set
+func applyLockImage(imagePath string) {
+	if !*i3lockImage {
+		return
+	}
+	dst := lockImagePath()
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		log.Printf("warning: failed to create config dir: %v\n", err)
+		return
+	}
+	// copy to a temporary file first, so i3lock never sees a partial image
+	tmp := dst + ".tmp"
+	defer os.Remove(tmp)
+	if err := copyFile(imagePath, tmp); err != nil {
+		log.Printf("warning: failed to copy lock image: %v\n", err)
+		return
+	}
+	if err := convertImage(tmp, "png"); err != nil {
+		log.Printf("warning: failed to convert lock image: %v\n", err)
+		return
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		log.Printf("warning: failed to update lock image: %v\n", err)
+	}
+}
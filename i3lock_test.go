@@ -0,0 +1,31 @@
+package main
+
+import (
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/adrg/xdg"
+)
+
+func TestApplyLockImage(t *testing.T) {
+	defer func(dir string, v bool) { xdg.ConfigHome, *i3lockImage = dir, v }(xdg.ConfigHome, *i3lockImage)
+	xdg.ConfigHome, *i3lockImage = t.TempDir(), true
+	applyLockImage(filepath.Join("testdata", "test_image.jpg"))
+	f, err := os.Open(lockImagePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, format, err := image.DecodeConfig(f); err != nil || format != "png" {
+		t.Errorf("got lock image format %q (%v), want png", format, err)
+	}
+	if _, err := os.Stat(lockImagePath() + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary lock image left behind")
+	}
+	if !strings.Contains(i3lockConfig(), "lock.png") {
+		t.Errorf("i3lock config does not use the PNG: %s", i3lockConfig())
+	}
+}
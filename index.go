@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// indexFile holds structured metadata about all cached images
+const indexFile = "index.json"
+
+// indexEntry describes a cached image in the index
+type indexEntry struct {
+	URL       string    `json:"url"`
+	Path      string    `json:"path"`
+	Title     string    `json:"title,omitempty"`
+	Date      string    `json:"date,omitempty"`
+	MediaType string    `json:"media_type,omitempty"`
+	Size      int64     `json:"size"`
+	ModTime   time.Time `json:"mod_time"`
+}
+
+// cacheIndex maps image URLs to their index entries
+type cacheIndex map[string]indexEntry
+
+// indexPath returns the location of the index file
+func indexPath() string {
+	return filepath.Join(cacheDir, indexFile)
+}
+
+// loadIndex reads the index, a missing index is empty
+func loadIndex() (cacheIndex, error) {
+	idx := make(cacheIndex)
+	b, err := os.ReadFile(indexPath())
+	if os.IsNotExist(err) {
+		return idx, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &idx); err != nil {
+		return nil, fmt.Errorf("failed to parse index: %w", err)
+	}
+	return idx, nil
+}
+
+// saveIndex writes the index
+func saveIndex(idx cacheIndex) error {
+	b, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(indexPath(), b, 0644)
+}
+
+// newIndexEntry creates an index entry for a cached image
+func newIndexEntry(imagePath string, meta imageMeta) (indexEntry, error) {
+	fi, err := os.Stat(imagePath)
+	if err != nil {
+		return indexEntry{}, err
+	}
+	return indexEntry{
+		URL:       meta.URL,
+		Path:      imagePath,
+		Title:     meta.Title,
+		Date:      meta.Date,
+		MediaType: meta.MediaType,
+		Size:      fi.Size(),
+		ModTime:   fi.ModTime(),
+	}, nil
+}
+
+// indexImage adds or updates a single image in the index; failures are only
+// logged, since the index can be rebuilt from the sidecars
+func indexImage(imagePath string, meta imageMeta) {
+	idx, err := loadIndex()
+	if err != nil {
+		log.Printf("warning: failed to load index: %v\n", err)
+		return
+	}
+	entry, err := newIndexEntry(imagePath, meta)
+	if err != nil {
+		log.Printf("warning: failed to index image: %v\n", err)
+		return
+	}
+	idx[entry.URL] = entry
+	if err := saveIndex(idx); err != nil {
+		log.Printf("warning: failed to save index: %v\n", err)
+	}
+}
+
+// unindexImage removes all index entries pointing at a cached image
+func unindexImage(imagePath string) {
+	idx, err := loadIndex()
+	if err != nil {
+		log.Printf("warning: failed to load index: %v\n", err)
+		return
+	}
+	for url, entry := range idx {
+		if entry.Path == imagePath {
+			delete(idx, url)
+		}
+	}
+	if err := saveIndex(idx); err != nil {
+		log.Printf("warning: failed to save index: %v\n", err)
+	}
+}
+
+// rebuildIndex scans all cached images and their sidecars and updates the
+// index accordingly, entries are keyed by URL, so they are never duplicated
+func rebuildIndex() error {
+	idx, err := loadIndex()
+	if err != nil {
+		return err
+	}
+	images, err := cachedImages()
+	if err != nil {
+		return err
+	}
+	var added, updated, skipped int
+	for i, imagePath := range images {
+		fmt.Fprintf(os.Stderr, "[%d/%d] %s\n", i+1, len(images), filepath.Base(imagePath))
+		meta, err := readImageMeta(imagePath)
+		if err != nil || meta.URL == "" {
+			skipped++
+			continue
+		}
+		entry, err := newIndexEntry(imagePath, meta)
+		if err != nil {
+			skipped++
+			continue
+		}
+		if _, ok := idx[entry.URL]; ok {
+			updated++
+		} else {
+			added++
+		}
+		idx[entry.URL] = entry
+	}
+	if err := saveIndex(idx); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%d added, %d updated, %d skipped\n", added, updated, skipped)
+	return nil
+}
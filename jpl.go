@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+)
+
+// jplFeaturedURL is the JPL featured images endpoint, a variable so tests can
+// point it at a local server; it is not publicly documented, so a missing
+// endpoint or a response that is not JSON makes the source unavailable
+// rather than failing the run
+var jplFeaturedURL = "https://images.jpl.nasa.gov/api/v1/images?featured=true"
+
+// errSourceUnavailable is returned by sources whose endpoint is gone or
+// answers with something else than its API; they are skipped
+var errSourceUnavailable = errors.New("source unavailable")
+
+// JPLImage represents an image from the JPL featured collection
+type JPLImage struct {
+	ID          string `json:"id"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Date        string `json:"date"`
+	ImageURL    string `json:"image_url"`
+	FullResURL  string `json:"full_res_url"`
+}
+
+// JPLResponse represents the response from the JPL featured images endpoint
+type JPLResponse struct {
+	Items []JPLImage `json:"items"`
+}
+
+// fetchJPL fetches and displays a random featured JPL image URL, or an APOD
+// while the JPL endpoint is unavailable
+func fetchJPL(apiKey string, setWallpaper bool) error {
+	return fetchImage(func() (imageMeta, error) { return selectJPLOrAPOD(apiKey) }, setWallpaper)
+}
+
+// selectJPLOrAPOD picks a featured JPL image, or an APOD if the JPL endpoint
+// is unavailable
+func selectJPLOrAPOD(apiKey string) (imageMeta, error) {
+	meta, err := selectJPL()
+	if errors.Is(err, errSourceUnavailable) {
+		verbosef("skipping JPL: %v", err)
+		return selectAPOD(apiKey)
+	}
+	return meta, err
+}
+
+// selectJPL picks a random featured JPL image and returns its image metadata
+func selectJPL() (imageMeta, error) {
+	res, err := fetchURL(jplFeaturedURL)
+	if errors.Is(err, errNotFound) {
+		return imageMeta{}, fmt.Errorf("%w: JPL featured images: %v", errSourceUnavailable, err)
+	}
+	if err != nil {
+		return imageMeta{}, fmt.Errorf("failed to fetch JPL images: %w", err)
+	}
+	var jplResp JPLResponse
+	if err := parseJSON(res.Body, res.ContentType, &jplResp); err != nil {
+		return imageMeta{}, fmt.Errorf("%w: JPL featured images: %v", errSourceUnavailable, err)
+	}
+	var candidates []JPLImage
+	for _, img := range jplResp.Items {
+		if img.FullResURL != "" || img.ImageURL != "" {
+			candidates = append(candidates, img)
+		}
+	}
+	if len(candidates) == 0 {
+		return imageMeta{}, fmt.Errorf("no featured JPL images found")
+	}
+	var (
+		img      = candidates[rand.Intn(len(candidates))]
+		imageURL = img.FullResURL
+	)
+	if imageURL == "" {
+		imageURL = img.ImageURL
+	}
+	return imageMeta{
+		URL:       normalizeImageURL(imageURL),
+		Title:     img.Title,
+		Date:      img.Date,
+		MediaType: "image",
+	}, nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSelectJPLUnavailable(t *testing.T) {
+	testServer(t)
+	defer func(u, d string) { jplFeaturedURL, *apodDay = u, d }(jplFeaturedURL, *apodDay)
+	*apodDay = "2024-01-10"
+	for name, handler := range map[string]http.HandlerFunc{
+		"not found": func(w http.ResponseWriter, r *http.Request) {
+			http.NotFound(w, r)
+		},
+		"not json": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html><body>Moved</body></html>"))
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			srv := httptest.NewServer(handler)
+			defer srv.Close()
+			jplFeaturedURL = srv.URL
+			if _, err := selectJPL(); !errors.Is(err, errSourceUnavailable) {
+				t.Fatalf("selectJPL: got %v, want %v", err, errSourceUnavailable)
+			}
+			meta, err := selectJPLOrAPOD("DEMO_KEY")
+			if err != nil {
+				t.Fatalf("selectJPLOrAPOD: %v", err)
+			}
+			if meta.Date != "2024-01-10" {
+				t.Errorf("got image dated %q, want the APOD of 2024-01-10", meta.Date)
+			}
+		})
+	}
+}
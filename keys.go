@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// keyStateFile records when API keys ran out of requests
+	keyStateFile = "keys.json"
+	// rateLimitWindow is how long a rate limited key stays disabled
+	rateLimitWindow = time.Hour
+)
+
+// keyState maps a hash of each rate limited API key to the time it ran out;
+// the keys themselves are not written to disk
+type keyState map[string]time.Time
+
+// keyID returns the identifier of an API key in the key state file
+func keyID(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return fmt.Sprintf("%x", h[:8])
+}
+
+// loadKeyState reads the key state file, an empty state if there is none
+func loadKeyState() keyState {
+	st := make(keyState)
+	b, err := os.ReadFile(filepath.Join(cacheDir, keyStateFile))
+	if err != nil {
+		return st
+	}
+	if err := json.Unmarshal(b, &st); err != nil {
+		log.Printf("warning: failed to parse key state: %v\n", err)
+	}
+	return st
+}
+
+// saveKeyState writes the key state file, dropping expired entries
+func saveKeyState(st keyState) {
+	for id, t := range st {
+		if time.Since(t) > rateLimitWindow {
+			delete(st, id)
+		}
+	}
+	b, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		log.Printf("warning: failed to encode key state: %v\n", err)
+		return
+	}
+	if err := os.WriteFile(filepath.Join(cacheDir, keyStateFile), b, 0644); err != nil {
+		log.Printf("warning: failed to write key state: %v\n", err)
+	}
+}
+
+// apiKeys returns the primary key followed by the -key-pool keys
+func apiKeys(primary string) []string {
+	keys := []string{primary}
+	for _, k := range strings.Split(*keyPool, ",") {
+		if k = strings.TrimSpace(k); k != "" && k != primary {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// activeKey returns the first of the primary and the pool keys that has not
+// been rate limited within the last hour; if all are, the primary is used
+func activeKey(primary string) string {
+	st := loadKeyState()
+	for _, k := range apiKeys(primary) {
+		if t, ok := st[keyID(k)]; !ok || time.Since(t) > rateLimitWindow {
+			return k
+		}
+	}
+	return primary
+}
+
+// otherKeyLeft reports whether -key-pool has a key that is not rate limited
+// besides the API key of a request
+func otherKeyLeft(u *url.URL) bool {
+	key := u.Query().Get("api_key")
+	if key == "" || *keyPool == "" {
+		return false
+	}
+	st := loadKeyState()
+	for _, k := range apiKeys(key)[1:] {
+		if t, ok := st[keyID(k)]; !ok || time.Since(t) > rateLimitWindow {
+			return true
+		}
+	}
+	return false
+}
+
+// markRateLimited records that an API key has no requests left
+func markRateLimited(key string) {
+	st := loadKeyState()
+	if _, ok := st[keyID(key)]; !ok && *keyPool != "" {
+		log.Printf("API key %s... is rate limited, switching keys", key[:min(len(key), 4)])
+	}
+	st[keyID(key)] = time.Now()
+	saveKeyState(st)
+}
+
+// observeRateLimit marks the API key of a request as rate limited when the
+// response is a 429 or reports no remaining requests
+func observeRateLimit(requestURL string, resp *http.Response) {
+	u, err := url.Parse(requestURL)
+	if err != nil {
+		return
+	}
+	key := u.Query().Get("api_key")
+	if key == "" {
+		return
+	}
+	remaining, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining"))
+	if resp.StatusCode == http.StatusTooManyRequests || (err == nil && remaining <= 0) {
+		markRateLimited(key)
+	}
+}
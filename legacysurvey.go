@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"net/url"
+)
+
+const (
+	legacySurveyURL   = "https://www.legacysurvey.org/viewer/jpeg-cutout"
+	legacySurveyLayer = "ls-dr10"
+	legacySurveyScale = 0.262 // arcsec per pixel, the native survey resolution
+	legacySurveyW     = 2560
+	legacySurveyH     = 1440
+)
+
+// north galactic pole and galactic longitude of the north celestial pole,
+// J2000, in degrees
+const (
+	raNGP  = 192.85948
+	decNGP = 27.12825
+	lNCP   = 122.93192
+)
+
+// galacticToEquatorial converts galactic coordinates to J2000 right
+// ascension and declination, all in degrees
+func galacticToEquatorial(l, b float64) (ra, dec float64) {
+	var (
+		rad  = math.Pi / 180
+		sinb = math.Sin(b * rad)
+		cosb = math.Cos(b * rad)
+		sinD = math.Sin(decNGP * rad)
+		cosD = math.Cos(decNGP * rad)
+		dl   = (lNCP - l) * rad
+	)
+	dec = math.Asin(sinb*sinD+cosb*cosD*math.Cos(dl)) / rad
+	ra = raNGP + math.Atan2(cosb*math.Sin(dl), sinb*cosD-cosb*sinD*math.Cos(dl))/rad
+	ra = math.Mod(ra+360, 360)
+	return ra, dec
+}
+
+// legacySurveyCutoutURL returns the URL of a JPEG cutout centered on the
+// given coordinates
+func legacySurveyCutoutURL(ra, dec float64) string {
+	v := url.Values{}
+	v.Set("ra", fmt.Sprintf("%.5f", ra))
+	v.Set("dec", fmt.Sprintf("%.5f", dec))
+	v.Set("layer", legacySurveyLayer)
+	v.Set("pixscale", fmt.Sprintf("%g", legacySurveyScale))
+	v.Set("width", fmt.Sprint(legacySurveyW))
+	v.Set("height", fmt.Sprint(legacySurveyH))
+	return legacySurveyURL + "?" + v.Encode()
+}
+
+// fetchLegacySurvey fetches and displays a Legacy Survey cutout URL for a
+// random region of the Milky Way plane
+func fetchLegacySurvey(setWallpaper bool) error {
+	return fetchImage(selectLegacySurvey, setWallpaper)
+}
+
+// selectLegacySurvey picks random coordinates within -galactic-latitude-range
+// degrees of the galactic plane and returns the cutout image metadata
+func selectLegacySurvey() (imageMeta, error) {
+	if *galacticLat < 0 || *galacticLat > 90 {
+		return imageMeta{}, fmt.Errorf("galactic latitude range must be between 0 and 90")
+	}
+	var (
+		l       = rand.Float64() * 360
+		b       = (rand.Float64()*2 - 1) * *galacticLat
+		ra, dec = galacticToEquatorial(l, b)
+	)
+	return imageMeta{
+		URL:       legacySurveyCutoutURL(ra, dec),
+		Title:     fmt.Sprintf("Legacy Surveys, RA %.4f Dec %.4f (l %.2f b %.2f)", ra, dec, l, b),
+		MediaType: "image",
+	}, nil
+}
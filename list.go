@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+)
+
+// readImageList reads a newline delimited list of image URLs, skipping empty
+// lines and lines starting with #
+func readImageList(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var (
+		urls    []string
+		scanner = bufio.NewScanner(f)
+	)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		urls = append(urls, line)
+	}
+	return urls, scanner.Err()
+}
+
+// fetchImageList displays a random image URL from a list file
+func fetchImageList(path string, setWallpaper bool) error {
+	return fetchImage(func() (imageMeta, error) { return selectImageList(path) }, setWallpaper)
+}
+
+// selectImageList picks a random image URL from a list file
+func selectImageList(path string) (imageMeta, error) {
+	urls, err := readImageList(path)
+	if err != nil {
+		return imageMeta{}, fmt.Errorf("failed to read image list: %w", err)
+	}
+	if len(urls) == 0 {
+		return imageMeta{}, fmt.Errorf("no image URLs in %s", path)
+	}
+	return imageMeta{
+		URL:       normalizeImageURL(urls[rand.Intn(len(urls))]),
+		MediaType: "image",
+	}, nil
+}
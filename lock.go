@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// lockPollInterval is how often a held lock is checked
+const lockPollInterval = 100 * time.Millisecond
+
+// acquireLock creates a lock file next to path, waiting up to wait for
+// another process to release it; lock files older than wait are considered
+// stale and removed. The returned function releases the lock.
+func acquireLock(path string, wait time.Duration) (func(), error) {
+	var (
+		lockPath = path + ".lock"
+		deadline = time.Now().Add(wait)
+	)
+	for {
+		f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			f.WriteString(strconv.Itoa(os.Getpid()))
+			f.Close()
+			return func() { os.Remove(lockPath) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("failed to create lock file: %w", err)
+		}
+		if fi, err := os.Stat(lockPath); err == nil && time.Since(fi.ModTime()) > wait {
+			os.Remove(lockPath)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for lock %s", lockPath)
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
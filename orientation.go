@@ -0,0 +1,41 @@
+package main
+
+import (
+	"image"
+	"os"
+)
+
+// preferredOrientation returns the orientation requested by flags, or an
+// empty string if there is no preference
+func preferredOrientation() string {
+	switch {
+	case *preferLandsc:
+		return "landscape"
+	case *preferPortrait:
+		return "portrait"
+	default:
+		return ""
+	}
+}
+
+// imageOrientation returns landscape or portrait for an image, from its
+// header only; square images count as both
+func imageOrientation(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case cfg.Width > cfg.Height:
+		return "landscape", nil
+	case cfg.Width < cfg.Height:
+		return "portrait", nil
+	default:
+		return "square", nil
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net/url"
+	"strings"
+)
+
+const (
+	panstarrsFilesURL  = "https://ps1images.stsci.edu/cgi-bin/ps1filenames.py"
+	panstarrsCutoutURL = "https://ps1images.stsci.edu/cgi-bin/fitscut.cgi"
+	// panstarrsSize is the cutout size in 0.25 arcsec pixels
+	panstarrsSize = 2400
+)
+
+// messierObject is a sky target for -panstarrs, coordinates in degrees
+type messierObject struct {
+	Name    string
+	RA, Dec float64
+}
+
+// messierObjects are Messier objects within the Pan-STARRS footprint, which
+// covers the sky north of declination -30
+var messierObjects = []messierObject{
+	{"M1 Crab Nebula", 83.633, 22.015},
+	{"M8 Lagoon Nebula", 270.904, -24.387},
+	{"M13 Hercules Cluster", 250.423, 36.461},
+	{"M16 Eagle Nebula", 274.700, -13.807},
+	{"M17 Omega Nebula", 275.196, -16.171},
+	{"M20 Trifid Nebula", 270.675, -22.971},
+	{"M27 Dumbbell Nebula", 299.901, 22.721},
+	{"M31 Andromeda Galaxy", 10.685, 41.269},
+	{"M42 Orion Nebula", 83.822, -5.391},
+	{"M45 Pleiades", 56.750, 24.117},
+	{"M51 Whirlpool Galaxy", 202.470, 47.195},
+	{"M57 Ring Nebula", 283.396, 33.029},
+	{"M64 Black Eye Galaxy", 194.182, 21.683},
+	{"M74 Phantom Galaxy", 24.174, 15.784},
+	{"M81 Bode's Galaxy", 148.888, 69.065},
+	{"M82 Cigar Galaxy", 148.970, 69.680},
+	{"M87 Virgo A", 187.706, 12.391},
+	{"M97 Owl Nebula", 168.699, 55.019},
+	{"M101 Pinwheel Galaxy", 210.802, 54.349},
+	{"M104 Sombrero Galaxy", 189.998, -11.623},
+}
+
+// panstarrsFiles returns the image file names for a position by filter,
+// parsed from the whitespace separated table of ps1filenames.py
+func panstarrsFiles(ra, dec float64, filters string) (map[string]string, error) {
+	v := url.Values{}
+	v.Set("ra", fmt.Sprintf("%f", ra))
+	v.Set("dec", fmt.Sprintf("%f", dec))
+	v.Set("filters", filters)
+	res, err := fetchURL(panstarrsFilesURL + "?" + v.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Pan-STARRS images: %w", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(res.Body)), "\n")
+	if len(lines) < 2 {
+		return nil, fmt.Errorf("no Pan-STARRS images at %f %f", ra, dec)
+	}
+	var (
+		header             = strings.Fields(lines[0])
+		filterCol, fileCol = -1, -1
+		files              = make(map[string]string)
+	)
+	for i, name := range header {
+		switch name {
+		case "filter":
+			filterCol = i
+		case "filename":
+			fileCol = i
+		}
+	}
+	if filterCol < 0 || fileCol < 0 {
+		return nil, fmt.Errorf("unexpected Pan-STARRS file list header: %s", lines[0])
+	}
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		if len(fields) > max(filterCol, fileCol) {
+			files[fields[filterCol]] = fields[fileCol]
+		}
+	}
+	return files, nil
+}
+
+// fetchPanSTARRS fetches and displays a Pan-STARRS cutout URL of a random
+// Messier object
+func fetchPanSTARRS(setWallpaper bool) error {
+	return fetchImage(selectPanSTARRS, setWallpaper)
+}
+
+// selectPanSTARRS picks a random Messier object and returns the metadata of
+// its i, z, y color composite cutout
+func selectPanSTARRS() (imageMeta, error) {
+	obj := messierObjects[rand.Intn(len(messierObjects))]
+	files, err := panstarrsFiles(obj.RA, obj.Dec, "izy")
+	if err != nil {
+		return imageMeta{}, err
+	}
+	v := url.Values{}
+	v.Set("ra", fmt.Sprintf("%f", obj.RA))
+	v.Set("dec", fmt.Sprintf("%f", obj.Dec))
+	v.Set("size", fmt.Sprint(panstarrsSize))
+	v.Set("format", "jpg")
+	// longest wavelength in red, shortest in blue
+	for color, filter := range map[string]string{"red": "y", "green": "z", "blue": "i"} {
+		name, ok := files[filter]
+		if !ok {
+			return imageMeta{}, fmt.Errorf("no Pan-STARRS %s image for %s", filter, obj.Name)
+		}
+		v.Set(color, name)
+	}
+	return imageMeta{
+		URL:       panstarrsCutoutURL + "?" + v.Encode(),
+		Title:     obj.Name + " (Pan-STARRS)",
+		MediaType: "image",
+	}, nil
+}
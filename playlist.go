@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// exportM3U writes a playlist of all cached images, with their title and
+// original URL from the sidecar as comments, to path; "-" is stdout
+func exportM3U(path string) error {
+	images, err := cachedImages()
+	if err != nil {
+		return err
+	}
+	out := os.Stdout
+	if path != "-" {
+		if out, err = os.Create(path); err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+	w := bufio.NewWriter(out)
+	fmt.Fprintln(w, "#EXTM3U")
+	for _, imagePath := range images {
+		absPath, err := filepath.Abs(imagePath)
+		if err != nil {
+			return err
+		}
+		meta, _ := readImageMeta(imagePath)
+		title := meta.Title
+		if title == "" {
+			title = filepath.Base(imagePath)
+		}
+		// titles end up on a single comment line
+		title = strings.Join(strings.Fields(title), " ")
+		fmt.Fprintf(w, "#EXTINF:-1,%s\n", title)
+		if meta.URL != "" {
+			fmt.Fprintf(w, "#EXTURL:%s\n", meta.URL)
+		}
+		fmt.Fprintln(w, absPath)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if path != "-" {
+		return out.Close()
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+)
+
+// policyCandidates is the number of random dates scored per weighted pick
+const policyCandidates = 32
+
+// weightedPolicy reports whether any selection weight is set
+func weightedPolicy() bool {
+	return *weightRecency != 0 || *weightNovelty != 0
+}
+
+// scoreDate scores a candidate date using the selection weights:
+//
+//	score = 1 + weightRecency*recency + weightNovelty*novelty
+//
+// where recency is the position of the date in the range, from 0 for the
+// first APOD to 1 for today, and novelty is 1 if the date has neither been
+// downloaded before nor is in the -history-size history, 0 otherwise.
+// Scores are clamped at a small positive value, so negative weights
+// penalize without excluding. The policy only applies to random APOD dates.
+func scoreDate(t, start, end time.Time, seen map[string]bool) float64 {
+	var (
+		recency = float64(calendarDays(start, t)) / float64(max(calendarDays(start, end), 1))
+		novelty = 1.0
+	)
+	if seen[t.Format("2006-01-02")] {
+		novelty = 0
+	}
+	return max(1+*weightRecency*recency+*weightNovelty*novelty, 0.01)
+}
+
+// weightedDate samples a number of random dates between start and end and
+// picks one of them with a probability proportional to its score
+func weightedDate(start, end time.Time) time.Time {
+	var (
+		days       = calendarDays(start, end) + 1
+		seen       = seenDates()
+		candidates = make([]time.Time, policyCandidates)
+		scores     = make([]float64, policyCandidates)
+		total      float64
+	)
+	for i := range candidates {
+		candidates[i] = start.AddDate(0, 0, rand.Intn(days))
+		scores[i] = scoreDate(candidates[i], start, end, seen)
+		total += scores[i]
+	}
+	r := rand.Float64() * total
+	for i, score := range scores {
+		if r -= score; r <= 0 {
+			return candidates[i]
+		}
+	}
+	return candidates[len(candidates)-1]
+}
+
+// seenDates returns the dates of all images in the cache index and the
+// dates in the history
+func seenDates() map[string]bool {
+	seen := make(map[string]bool)
+	for _, date := range loadHistory() {
+		seen[date] = true
+	}
+	idx, err := loadIndex()
+	if err != nil {
+		return seen
+	}
+	for _, entry := range idx {
+		if len(entry.Date) >= 10 {
+			seen[entry.Date[:10]] = true
+		}
+	}
+	return seen
+}
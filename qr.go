@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/jpeg"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/skip2/go-qrcode"
+	"golang.org/x/image/draw"
+)
+
+// apodPageURL returns the URL of the APOD web page for an image, or an empty
+// string if the image does not come from APOD
+func apodPageURL(meta imageMeta) string {
+	u, err := url.Parse(meta.URL)
+	if err != nil || u.Hostname() != "apod.nasa.gov" {
+		return ""
+	}
+	t, err := time.Parse("2006-01-02", meta.Date)
+	if err != nil {
+		return ""
+	}
+	return "https://apod.nasa.gov/apod/ap" + t.Format("060102") + ".html"
+}
+
+// qrPositions are the accepted -qr-position values
+var qrPositions = []string{"top-left", "top-right", "bottom-left", "bottom-right"}
+
+// qrCorner returns the rectangle of a size by size square in the corner of
+// r given by position, keeping a margin to the edges
+func qrCorner(r image.Rectangle, size int, position string) (image.Rectangle, error) {
+	var (
+		margin = size / 4
+		x      = r.Max.X - size - margin
+		y      = r.Max.Y - size - margin
+	)
+	switch position {
+	case "bottom-right":
+	case "bottom-left":
+		x = r.Min.X + margin
+	case "top-right":
+		y = r.Min.Y + margin
+	case "top-left":
+		x, y = r.Min.X+margin, r.Min.Y+margin
+	default:
+		return image.Rectangle{}, fmt.Errorf("unknown QR code position: %s", position)
+	}
+	return image.Rect(x, y, x+size, y+size), nil
+}
+
+// annotateQR composites a QR code linking to the APOD page of an image into
+// one of its corners; returns the path of the image to use, which is the
+// unchanged input if the image has no APOD page
+func annotateQR(imagePath string, meta imageMeta) (string, error) {
+	link := apodPageURL(meta)
+	if link == "" {
+		verbosef("not adding QR code to %s: no APOD page", imagePath)
+		return imagePath, nil
+	}
+	f, err := os.Open(imagePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	src, _, err := image.Decode(f)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode image: %w", err)
+	}
+	qr, err := qrcode.New(link, qrcode.Medium)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode QR code: %w", err)
+	}
+	corner, err := qrCorner(src.Bounds(), *qrSize, *qrPosition)
+	if err != nil {
+		return "", err
+	}
+	if !corner.In(src.Bounds()) {
+		return "", fmt.Errorf("image is too small for a %dpx QR code", *qrSize)
+	}
+	dst := image.NewRGBA(src.Bounds())
+	draw.Draw(dst, dst.Bounds(), src, src.Bounds().Min, draw.Src)
+	draw.Draw(dst, corner, qr.Image(*qrSize), image.Point{}, draw.Src)
+	var (
+		base    = strings.TrimSuffix(filepath.Base(imagePath), filepath.Ext(imagePath))
+		outPath = filepath.Join(cacheDir, fmt.Sprintf("qr_%s.jpg", base))
+	)
+	out, err := os.Create(outPath)
+	if err != nil {
+		return "", err
+	}
+	if err := jpeg.Encode(out, dst, &jpeg.Options{Quality: 92}); err != nil {
+		out.Close()
+		return "", err
+	}
+	return outPath, out.Close()
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"image"
+	"strings"
+	"testing"
+)
+
+func TestQRPosition(t *testing.T) {
+	defer func(p string) { *qrPosition = p }(*qrPosition)
+	r := image.Rect(0, 0, 1000, 800)
+	for _, p := range qrPositions {
+		*qrPosition = p
+		if err := validateFlags(); err != nil {
+			t.Errorf("%s: %v", p, err)
+		}
+		if _, err := qrCorner(r, 100, p); err != nil {
+			t.Errorf("%s: %v", p, err)
+		}
+	}
+	*qrPosition = "center"
+	if err := validateFlags(); err == nil || !strings.Contains(err.Error(), "-qr-position") {
+		t.Errorf("got %v for -qr-position center, want an error", err)
+	}
+}
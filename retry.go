@@ -0,0 +1,75 @@
+package main
+
+import (
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// retryBase is the backoff before the first retry, doubled each time
+	retryBase = time.Second
+	// maxRetryWait is the longest wait before a retry; responses asking to
+	// come back later than that are returned as they are
+	maxRetryWait = time.Minute
+)
+
+// retryable reports whether a response status is worth retrying
+func retryable(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryDelay returns how long to wait before retry number attempt, from the
+// Retry-After header if the server sent one, with exponential backoff and
+// jitter otherwise
+func retryDelay(resp *http.Response, attempt int) time.Duration {
+	if s := resp.Header.Get("Retry-After"); s != "" {
+		if secs, err := strconv.Atoi(s); err == nil {
+			return time.Duration(secs) * time.Second
+		}
+		if t, err := http.ParseTime(s); err == nil {
+			return time.Until(t)
+		}
+	}
+	backoff := retryBase << attempt
+	return backoff + time.Duration(rand.Int63n(int64(backoff)))
+}
+
+// doWithRetry sends a request without body, retrying up to -r times on rate
+// limits and server errors; rate limits are returned at once if -key-pool
+// has another key left
+func doWithRetry(client *http.Client, req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := client.Do(req)
+		if err != nil || !retryable(resp.StatusCode) || attempt >= *retries {
+			return resp, err
+		}
+		if resp.StatusCode == http.StatusTooManyRequests && otherKeyLeft(req.URL) {
+			// the caller switches to the next -key-pool key instead
+			return resp, nil
+		}
+		delay := retryDelay(resp, attempt)
+		if delay > maxRetryWait {
+			return resp, nil
+		}
+		resp.Body.Close()
+		// the query is left out, it may hold the API key
+		verbosef("%s returned status %d, retrying in %s", req.URL.Host+req.URL.Path, resp.StatusCode, delay.Round(time.Millisecond))
+		time.Sleep(delay)
+	}
+}
+
+// getWithRetry is like http.Get with the shared client and retries
+func getWithRetry(url string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return doWithRetry(httpClient, req)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRateLimitRotatesBeforeRetrying(t *testing.T) {
+	testServer(t) // for the HTTP client and cache dir
+	requests := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.FormValue("api_key")
+		requests[key]++
+		if key == "exhausted" {
+			// retrying at once would not slow the test down
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"date":"2024-01-10","title":"Test","url":"https://example.com/a.jpg","media_type":"image"}`))
+	}))
+	defer srv.Close()
+	defer func(pool string, r int) { *keyPool, *retries = pool, r }(*keyPool, *retries)
+	*keyPool, *retries = "fresh", 3
+	apodURL = srv.URL
+	apod, err := loadAPOD("exhausted", "2024-01-10")
+	if err != nil {
+		t.Fatalf("loadAPOD: %v", err)
+	}
+	if apod.Title != "Test" {
+		t.Errorf("got title %q, want Test", apod.Title)
+	}
+	if requests["exhausted"] != 1 || requests["fresh"] != 1 {
+		t.Errorf("got requests per key %v, want one each", requests)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+)
+
+// sourceNames are the image sources that can be selected by name
+var sourceNames = []string{"apod", "nasa", "jpl", "earth", "spacex", "legacysurvey", "github", "epic", "panstarrs", "hubblesite"}
+
+// sourceOrder is the parsed -sources list
+var sourceOrder []string
+
+// compileSources parses -sources, a comma separated list of source names
+// that may also be written like ["apod", "nasa:nebula"]
+func compileSources() error {
+	sourceOrder = nil
+	list := strings.Trim(strings.TrimSpace(*sourceList), "[]")
+	for _, name := range strings.Split(list, ",") {
+		name = strings.Trim(strings.TrimSpace(name), `"'`)
+		if name == "" {
+			continue
+		}
+		if base, _, _ := strings.Cut(name, ":"); !slices.Contains(sourceNames, base) {
+			return fmt.Errorf("unknown source %q in -sources, want one of %s", name, strings.Join(sourceNames, ", "))
+		}
+		sourceOrder = append(sourceOrder, name)
+	}
+	return nil
+}
+
+// selectFromSources tries the -sources in order and returns the first image
+// found; the error of every failed source is logged, unavailable sources are
+// only reported with -v
+func selectFromSources(apiKey string) (imageMeta, error) {
+	for _, name := range sourceOrder {
+		pick, err := namedSource(name, apiKey)
+		if err != nil {
+			return imageMeta{}, err
+		}
+		meta, err := pick()
+		if err == nil {
+			return meta, nil
+		}
+		if errors.Is(err, errSourceUnavailable) {
+			verbosef("skipping source %s: %v", name, err)
+			continue
+		}
+		log.Printf("source %s failed: %v", name, err)
+	}
+	return imageMeta{}, fmt.Errorf("all sources failed: %s", strings.Join(sourceOrder, ", "))
+}
+
+// primaryFailures counts consecutive rotation failures of the selected
+// source, for -fallback-source
+var primaryFailures int
+
+// namedSource returns the select function of a source by name; "nasa" may
+// carry a search query, as in "nasa:nebula", and uses -q otherwise
+func namedSource(name, apiKey string) (func() (imageMeta, error), error) {
+	name, arg, _ := strings.Cut(name, ":")
+	switch name {
+	case "apod":
+		return func() (imageMeta, error) { return selectAPOD(apiKey) }, nil
+	case "nasa":
+		if arg == "" {
+			arg = searchQuery()
+		}
+		return func() (imageMeta, error) { return selectNASAImage(arg, *keywords, *nasaPage, *nasaPageSize) }, nil
+	case "jpl":
+		return selectJPL, nil
+	case "earth":
+		return selectEarthObservatory, nil
+	case "spacex":
+		return selectSpaceX, nil
+	case "legacysurvey":
+		return selectLegacySurvey, nil
+	case "github":
+		return selectNASAGitHub, nil
+	case "epic":
+		return func() (imageMeta, error) { return selectEPIC(apiKey) }, nil
+	case "panstarrs":
+		return selectPanSTARRS, nil
+	case "hubblesite":
+		return selectHubblesite, nil
+	default:
+		return nil, fmt.Errorf("unknown source %q, want one of %s", name, strings.Join(sourceNames, ", "))
+	}
+}
+
+// rotate fetches an image from the selected source and optionally sets it
+// as wallpaper; after -fallback-after consecutive failures, failed rotations
+// are retried with -fallback-source; the selected source is still tried
+// first every time, so rotation switches back once it recovers
+func rotate(apiKey string, setWallpaper bool) error {
+	err := fetchImage(func() (imageMeta, error) { return selectImage(apiKey) }, setWallpaper)
+	if err == nil {
+		if *fallbackSource != "" && primaryFailures >= *fallbackAfter {
+			log.Printf("primary source recovered, switching back from %s", *fallbackSource)
+		}
+		primaryFailures = 0
+		return nil
+	}
+	primaryFailures++
+	if *fallbackSource == "" || primaryFailures < *fallbackAfter {
+		return err
+	}
+	if primaryFailures == *fallbackAfter {
+		log.Printf("primary source failed %d times, switching to %s: %v", primaryFailures, *fallbackSource, err)
+	}
+	pick, err := namedSource(*fallbackSource, apiKey)
+	if err != nil {
+		return err
+	}
+	return fetchImage(pick, setWallpaper)
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/jpeg"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// spanFactor is how much wider the combined monitors must be than the image
+// before the image gets resized to span them
+const spanFactor = 1.2
+
+// monitor is the geometry of a connected monitor
+type monitor struct {
+	w, h, x, y int
+	primary    bool
+}
+
+// listMonitors returns the connected monitors, as reported by xrandr or, on
+// Wayland compositors without XWayland, wlr-randr
+func listMonitors() ([]monitor, error) {
+	if out, err := exec.Command("xrandr", "--listmonitors").Output(); err == nil {
+		return parseMonitors(string(out))
+	}
+	out, err := exec.Command("wlr-randr").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list monitors with xrandr or wlr-randr: %w", err)
+	}
+	return parseWlrRandr(string(out))
+}
+
+// primaryMonitor returns the monitor marked as primary, or the first one
+func primaryMonitor(monitors []monitor) monitor {
+	for _, m := range monitors {
+		if m.primary {
+			return m
+		}
+	}
+	return monitors[0]
+}
+
+// screenArea returns the width and height of the bounding box of all
+// connected monitors
+func screenArea() (int, int, error) {
+	monitors, err := listMonitors()
+	if err != nil {
+		return 0, 0, err
+	}
+	var (
+		minX, minY = monitors[0].x, monitors[0].y
+		maxX, maxY = minX, minY
+	)
+	for _, m := range monitors {
+		minX, minY = min(minX, m.x), min(minY, m.y)
+		maxX, maxY = max(maxX, m.x+m.w), max(maxY, m.y+m.h)
+	}
+	return maxX - minX, maxY - minY, nil
+}
+
+// parseMonitors parses the output of xrandr --listmonitors, where each
+// monitor is listed like " 0: +*eDP-1 1920/344x1080/193+0+0  eDP-1" and the
+// star marks the primary one
+func parseMonitors(out string) ([]monitor, error) {
+	var monitors []monitor
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || !strings.HasSuffix(fields[0], ":") {
+			continue
+		}
+		w, h, x, y, ok := parseGeometry(fields[2])
+		if !ok {
+			continue
+		}
+		monitors = append(monitors, monitor{w: w, h: h, x: x, y: y, primary: strings.Contains(fields[1], "*")})
+	}
+	if len(monitors) == 0 {
+		return nil, fmt.Errorf("no monitors found")
+	}
+	return monitors, nil
+}
+
+// parseWlrRandr parses the output of wlr-randr, where each output starts
+// unindented and lists its current mode like
+// "    1920x1080 px, 60.000000 Hz (preferred, current)" and its position like
+// "  Position: 1920,0"; disabled outputs are left out
+func parseWlrRandr(out string) ([]monitor, error) {
+	var (
+		monitors []monitor
+		cur      *monitor
+		enabled  bool
+	)
+	flush := func() {
+		if cur != nil && enabled && cur.w > 0 {
+			monitors = append(monitors, *cur)
+		}
+	}
+	for _, line := range strings.Split(out, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, " ") {
+			flush()
+			cur, enabled = &monitor{}, true
+			continue
+		}
+		if cur == nil {
+			continue
+		}
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "Enabled: no" {
+			enabled = false
+		} else if pos, found := strings.CutPrefix(trimmed, "Position: "); found {
+			xs, ys, _ := strings.Cut(pos, ",")
+			cur.x, _ = strconv.Atoi(xs)
+			cur.y, _ = strconv.Atoi(ys)
+		} else if size, rest, found := strings.Cut(trimmed, " px,"); found && strings.Contains(rest, "current") {
+			if w, h, ok := parseSize(size); ok {
+				cur.w, cur.h = w, h
+			}
+		}
+	}
+	flush()
+	if len(monitors) == 0 {
+		return nil, fmt.Errorf("no monitors found")
+	}
+	return monitors, nil
+}
+
+// parseGeometry parses an xrandr geometry like 1920/344x1080/193+0+0
+func parseGeometry(s string) (w, h, x, y int, ok bool) {
+	size, offset, found := strings.Cut(s, "+")
+	if !found {
+		return
+	}
+	ws, hs, found := strings.Cut(size, "x")
+	if !found {
+		return
+	}
+	xs, ys, found := strings.Cut(offset, "+")
+	if !found {
+		return
+	}
+	ws, _, _ = strings.Cut(ws, "/")
+	hs, _, _ = strings.Cut(hs, "/")
+	var errs [4]error
+	w, errs[0] = strconv.Atoi(ws)
+	h, errs[1] = strconv.Atoi(hs)
+	x, errs[2] = strconv.Atoi(xs)
+	y, errs[3] = strconv.Atoi(ys)
+	for _, err := range errs {
+		if err != nil {
+			return 0, 0, 0, 0, false
+		}
+	}
+	return w, h, x, y, true
+}
+
+// spanImage resizes an image to cover the combined area of all monitors, if
+// the monitors are significantly wider than the image; returns the path of
+// the image to use
+func spanImage(imagePath string) (string, error) {
+	screenW, screenH, err := screenArea()
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(imagePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode image: %w", err)
+	}
+	if float64(screenW) < float64(cfg.Width)*spanFactor {
+		return imagePath, nil
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		return "", err
+	}
+	src, _, err := image.Decode(f)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode image: %w", err)
+	}
+	var (
+		dst  = image.NewRGBA(image.Rect(0, 0, screenW, screenH))
+		crop = coverRect(src.Bounds(), screenW, screenH)
+	)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, crop, draw.Src, nil)
+	var (
+		base    = strings.TrimSuffix(filepath.Base(imagePath), filepath.Ext(imagePath))
+		outPath = filepath.Join(cacheDir, fmt.Sprintf("span_%s_%dx%d.jpg", base, screenW, screenH))
+	)
+	out, err := os.Create(outPath)
+	if err != nil {
+		return "", err
+	}
+	if err := jpeg.Encode(out, dst, &jpeg.Options{Quality: 92}); err != nil {
+		out.Close()
+		return "", err
+	}
+	return outPath, out.Close()
+}
+
+// coverRect returns the centered part of r that has the aspect ratio of w:h
+func coverRect(r image.Rectangle, w, h int) image.Rectangle {
+	var (
+		rw, rh = r.Dx(), r.Dy()
+		cw, ch = rw, rw * h / w
+	)
+	if ch > rh {
+		cw, ch = rh*w/h, rh
+	}
+	x := r.Min.X + (rw-cw)/2
+	y := r.Min.Y + (rh-ch)/2
+	return image.Rect(x, y, x+cw, y+ch)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseMonitors(t *testing.T) {
+	tests := []struct {
+		name    string
+		parse   func(string) ([]monitor, error)
+		out     string
+		want    []monitor
+		primary monitor
+	}{
+		{
+			"xrandr", parseMonitors,
+			"Monitors: 2\n 0: +HDMI-1 2560/597x1440/336+0+0  HDMI-1\n 1: +*eDP-1 1920/344x1080/193+2560+0  eDP-1\n",
+			[]monitor{{w: 2560, h: 1440}, {w: 1920, h: 1080, x: 2560, primary: true}},
+			monitor{w: 1920, h: 1080, x: 2560, primary: true},
+		},
+		{
+			"wlr-randr", parseWlrRandr,
+			"eDP-1 \"Sharp Corporation\"\n  Enabled: yes\n  Modes:\n    1280x720 px, 60.000000 Hz\n    1920x1200 px, 59.950000 Hz (preferred, current)\n  Position: 0,0\n" +
+				"DP-1 \"Dell\"\n  Enabled: yes\n  Modes:\n    2560x1440 px, 59.951000 Hz (preferred, current)\n  Position: 1920,0\n" +
+				"HDMI-A-1 \"Off\"\n  Enabled: no\n  Modes:\n    1920x1080 px, 60.000000 Hz (preferred)\n",
+			[]monitor{{w: 1920, h: 1200}, {w: 2560, h: 1440, x: 1920}},
+			monitor{w: 1920, h: 1200},
+		},
+	}
+	for _, tt := range tests {
+		got, err := tt.parse(tt.out)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+		if p := primaryMonitor(got); p != tt.primary {
+			t.Errorf("%s: got primary %+v, want %+v", tt.name, p, tt.primary)
+		}
+	}
+	if _, err := parseMonitors("Monitors: 0\n"); err == nil {
+		t.Errorf("no error without monitors")
+	}
+}
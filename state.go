@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// stateFile keeps track of the currently set wallpaper
+const stateFile = "state.json"
+
+// wallpaperState describes the wallpaper set most recently by apodwall
+type wallpaperState struct {
+	Path  string    `json:"path"`
+	URL   string    `json:"url"`
+	Title string    `json:"title,omitempty"`
+	SetAt time.Time `json:"set_at"`
+}
+
+// statePath returns the location of the state file
+func statePath() string {
+	return filepath.Join(cacheDir, stateFile)
+}
+
+// loadState reads the state file
+func loadState() (wallpaperState, error) {
+	var st wallpaperState
+	b, err := os.ReadFile(statePath())
+	if err != nil {
+		return st, err
+	}
+	err = json.Unmarshal(b, &st)
+	return st, err
+}
+
+// saveState writes the state file
+func saveState(st wallpaperState) error {
+	b, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(statePath(), b, 0644)
+}
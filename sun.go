@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"time"
+)
+
+// julianUnixEpoch is the julian date of the unix epoch
+const julianUnixEpoch = 2440587.5
+
+// julianDate returns the julian date of t
+func julianDate(t time.Time) float64 {
+	return float64(t.Unix())/86400 + julianUnixEpoch
+}
+
+// fromJulianDate returns the time of a julian date
+func fromJulianDate(jd float64) time.Time {
+	return time.Unix(int64(math.Round((jd-julianUnixEpoch)*86400)), 0)
+}
+
+// sunTimes returns sunrise and sunset on the day of t at a location, with
+// the sunrise equation; ok is false during polar day or night
+func sunTimes(t time.Time, lat, lon float64) (sunrise, sunset time.Time, ok bool) {
+	var (
+		rad    = math.Pi / 180
+		n      = math.Round(julianDate(t) - 2451545.0 + 0.0008)
+		mean   = n - lon/360
+		m      = math.Mod(357.5291+0.98560028*mean, 360)
+		c      = 1.9148*math.Sin(m*rad) + 0.02*math.Sin(2*m*rad) + 0.0003*math.Sin(3*m*rad)
+		lambda = math.Mod(m+c+180+102.9372, 360)
+		noon   = 2451545.0 + mean + 0.0053*math.Sin(m*rad) - 0.0069*math.Sin(2*lambda*rad)
+		sinDec = math.Sin(lambda*rad) * math.Sin(23.4397*rad)
+		cosDec = math.Cos(math.Asin(sinDec))
+		cosH   = (math.Sin(-0.833*rad) - math.Sin(lat*rad)*sinDec) / (math.Cos(lat*rad) * cosDec)
+	)
+	if cosH < -1 || cosH > 1 {
+		return time.Time{}, time.Time{}, false
+	}
+	h := math.Acos(cosH) / rad
+	return fromJulianDate(noon - h/360), fromJulianDate(noon + h/360), true
+}
+
+// nextSunEvent returns the next sunrise or sunset after now, whichever of
+// them are requested, or the zero time if there is none in the next days
+func nextSunEvent(now time.Time, lat, lon float64, rise, set bool) time.Time {
+	var next time.Time
+	for d := -1; d <= 7; d++ {
+		sunrise, sunset, ok := sunTimes(now.AddDate(0, 0, d), lat, lon)
+		if !ok {
+			continue
+		}
+		for _, ev := range []struct {
+			t    time.Time
+			want bool
+		}{{sunrise, rise}, {sunset, set}} {
+			if ev.want && ev.t.After(now) && (next.IsZero() || ev.t.Before(next)) {
+				next = ev.t
+			}
+		}
+		if !next.IsZero() {
+			return next
+		}
+	}
+	return next
+}
+
+// nextMidnight returns the start of the local day after now
+func nextMidnight(now time.Time) time.Time {
+	now = now.Local()
+	return time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.Local)
+}
+
+// runSunDaemon sets a new wallpaper at every sunrise and/or sunset at the
+// -lat and -lon location, until the process is stopped; during polar day or
+// night it waits for the sun to rise or set again
+func runSunDaemon(apiKey string) error {
+	if *latitude < -90 || *latitude > 90 || *longitude < -180 || *longitude > 180 {
+		return fmt.Errorf("invalid location %f, %f", *latitude, *longitude)
+	}
+	ignoreBrokenPipe()
+	for {
+		next := nextSunEvent(time.Now(), *latitude, *longitude, *atSunrise, *atSunset)
+		if next.IsZero() {
+			// polar day or night, check again tomorrow
+			midnight := nextMidnight(time.Now())
+			log.Printf("no sunrise or sunset in the next days, checking again at %s", midnight.Format(time.RFC1123))
+			time.Sleep(time.Until(midnight))
+			continue
+		}
+		log.Printf("next rotation at %s", next.Local().Format(time.RFC1123))
+		time.Sleep(time.Until(next))
+		if err := rotate(apiKey, true); err != nil {
+			log.Printf("rotation failed: %v", err)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextSunEventPolar(t *testing.T) {
+	midsummer := time.Date(2024, 6, 21, 12, 0, 0, 0, time.UTC)
+	if next := nextSunEvent(midsummer, 85, 0, true, true); !next.IsZero() {
+		t.Errorf("got sun event %s in midnight sun", next)
+	}
+	if next := nextSunEvent(midsummer, -85, 0, true, true); !next.IsZero() {
+		t.Errorf("got sun event %s in polar night", next)
+	}
+	next := nextSunEvent(midsummer, 52.5, 13.4, true, false)
+	if next.IsZero() || !next.After(midsummer) || next.Sub(midsummer) > 24*time.Hour {
+		t.Errorf("got sunrise %s, want one within a day of %s", next, midsummer)
+	}
+}
+
+func TestNextMidnight(t *testing.T) {
+	now := time.Date(2024, 12, 31, 23, 30, 0, 0, time.Local)
+	if got, want := nextMidnight(now), time.Date(2025, 1, 1, 0, 0, 0, 0, time.Local); !got.Equal(want) {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
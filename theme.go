@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// activeTheme is the resolved -theme, dark, light or empty for no theme
+var activeTheme string
+
+// themeGenres are the APOD genres preferred for each theme
+var themeGenres = map[string]string{
+	"dark":  "nebula,galaxy,star cluster",
+	"light": "earth,planet,aurora",
+}
+
+// themeQueries are the NASA image queries used for each theme, unless -q is
+// set explicitly
+var themeQueries = map[string]string{
+	"dark":  "nebula",
+	"light": "earth",
+}
+
+// compileTheme resolves -theme, detecting the system color scheme for auto
+func compileTheme() error {
+	switch *theme {
+	case "":
+		activeTheme = ""
+	case "dark", "light":
+		activeTheme = *theme
+	case "auto":
+		activeTheme = "light"
+		if systemDarkMode() {
+			activeTheme = "dark"
+		}
+		verbosef("detected %s system theme", activeTheme)
+	default:
+		return fmt.Errorf("-theme must be dark, light or auto")
+	}
+	return nil
+}
+
+// systemDarkMode reports whether the system prefers a dark color scheme;
+// detection failures count as light
+func systemDarkMode() bool {
+	switch runtime.GOOS {
+	case "linux":
+		out, err := exec.Command("gsettings", "get", "org.gnome.desktop.interface", "color-scheme").Output()
+		return err == nil && strings.Contains(string(out), "dark")
+	case "darwin":
+		// the key only exists in dark mode
+		out, err := exec.Command("defaults", "read", "-g", "AppleInterfaceStyle").Output()
+		return err == nil && strings.TrimSpace(string(out)) == "Dark"
+	case "windows":
+		out, err := exec.Command("reg", "query", `HKCU\Software\Microsoft\Windows\CurrentVersion\Themes\Personalize`, "/v", "AppsUseLightTheme").Output()
+		return err == nil && strings.Contains(string(out), "0x0")
+	default:
+		return false
+	}
+}
+
+// flagSet reports whether a flag was given on the command line or is set in
+// the config file
+func flagSet(name string) bool {
+	return cmdlineFlags[name] || configFlags[name]
+}
+
+// searchQuery returns the NASA image query, -q or the one of the theme
+func searchQuery() string {
+	if q, ok := themeQueries[activeTheme]; ok && !flagSet("q") {
+		return q
+	}
+	return *query
+}
+
+// requestedGenres returns the comma separated APOD genres to use, -genre or
+// the ones of the theme
+func requestedGenres() string {
+	if *genreFlag != "" {
+		return *genreFlag
+	}
+	return themeGenres[activeTheme]
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// tryWindows is only available on Windows
+func tryWindows(imagePath string) error {
+	return fmt.Errorf("not supported on this platform")
+}
+
+// detachedProcAttr starts background processes in their own session, so
+// they outlive apodwall and its terminal
+func detachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true}
+}
@@ -0,0 +1,49 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	spiSetDeskWallpaper  = 0x0014
+	spifUpdateIniFile    = 0x01
+	spifSendWinIniChange = 0x02 // also known as SPIF_SENDCHANGE
+)
+
+var (
+	user32                   = syscall.NewLazyDLL("user32.dll")
+	procSystemParametersInfo = user32.NewProc("SystemParametersInfoW")
+)
+
+// tryWindows sets the wallpaper with SystemParametersInfoW; the change is
+// written to the user profile and broadcast, so it persists and shows
+// immediately
+func tryWindows(imagePath string) error {
+	// the call fails without a useful error for missing files
+	if _, err := os.Stat(imagePath); err != nil {
+		return fmt.Errorf("cannot use wallpaper: %w", err)
+	}
+	// paths are passed as UTF-16, so non-ASCII names work
+	p, err := syscall.UTF16PtrFromString(imagePath)
+	if err != nil {
+		return err
+	}
+	ret, _, err := procSystemParametersInfo.Call(
+		spiSetDeskWallpaper, 0, uintptr(unsafe.Pointer(p)),
+		spifUpdateIniFile|spifSendWinIniChange)
+	if ret == 0 {
+		return fmt.Errorf("SystemParametersInfoW failed: %w", err)
+	}
+	return nil
+}
+
+// detachedProcAttr returns no special attributes, Windows processes already
+// outlive their parent
+func detachedProcAttr() *syscall.SysProcAttr {
+	return nil
+}
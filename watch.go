@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// modTime returns the modification time of a file, or the zero time if it
+// does not exist
+func modTime(path string) (time.Time, error) {
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return fi.ModTime(), nil
+}
+
+// watchFile polls the modification time of a file and calls f whenever it
+// changes, including when the file is created; polling works the same on
+// every platform
+func watchFile(path string, interval time.Duration, f func() error) error {
+	if interval <= 0 {
+		return fmt.Errorf("invalid watch interval: %s", interval)
+	}
+	last, err := modTime(path)
+	if err != nil {
+		return err
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		mt, err := modTime(path)
+		if err != nil {
+			log.Printf("warning: failed to stat %s: %v\n", path, err)
+			continue
+		}
+		if mt.Equal(last) || mt.IsZero() {
+			last = mt
+			continue
+		}
+		last = mt
+		if err := f(); err != nil {
+			log.Printf("rotation on file change failed: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// backgroundStartup is how long a wallpaper daemon must keep running after
+// start to count as working
+const backgroundStartup = 500 * time.Millisecond
+
+// isWayland reports whether we run in a Wayland session
+func isWayland() bool {
+	return os.Getenv("WAYLAND_DISPLAY") != "" || os.Getenv("XDG_SESSION_TYPE") == "wayland"
+}
+
+// backgroundPIDFile returns the state file holding the PID of the wallpaper
+// daemon started last
+func backgroundPIDFile() string {
+	return filepath.Join(cacheDir, "background.pid")
+}
+
+// stopBackground terminates a wallpaper daemon recorded in a PID file
+// state like "swaybg 1234", if it is still running under that name
+func stopBackground(state []byte) {
+	name, pidStr, _ := strings.Cut(strings.TrimSpace(string(state)), " ")
+	pid, err := strconv.Atoi(pidStr)
+	if err != nil || pid <= 0 {
+		return
+	}
+	comm, err := os.ReadFile(fmt.Sprintf("/proc/%d/comm", pid))
+	if err != nil || strings.TrimSpace(string(comm)) != name {
+		return
+	}
+	if p, err := os.FindProcess(pid); err == nil {
+		p.Signal(syscall.SIGTERM)
+	}
+}
+
+// startBackground starts a wallpaper daemon like swaybg, which needs to keep
+// running to show the image, and records its PID; the previous one is only
+// stopped once the new one is up, to avoid a blank screen
+func startBackground(name string, args ...string) error {
+	if _, err := exec.LookPath(name); err != nil {
+		return err
+	}
+	previous, _ := os.ReadFile(backgroundPIDFile())
+	cmd := exec.Command(name, args...)
+	cmd.SysProcAttr = detachedProcAttr()
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	select {
+	case err := <-exited:
+		return fmt.Errorf("%s exited: %v", name, err)
+	case <-time.After(backgroundStartup):
+	}
+	stopBackground(previous)
+	state := fmt.Sprintf("%s %d\n", name, cmd.Process.Pid)
+	if err := os.WriteFile(backgroundPIDFile(), []byte(state), 0644); err != nil {
+		log.Printf("warning: failed to record %s PID: %v\n", name, err)
+	}
+	return nil
+}
+
+// tryWayland attempts to set the wallpaper on wlroots based compositors like
+// sway, river or labwc with swaybg, or wbg as a fallback; GNOME and KDE have
+// their own setters
+func tryWayland(imagePath string) error {
+	if !isWayland() {
+		return fmt.Errorf("not a Wayland session")
+	}
+	desktop := strings.ToUpper(os.Getenv("XDG_CURRENT_DESKTOP"))
+	if strings.Contains(desktop, "GNOME") || strings.Contains(desktop, "KDE") {
+		return fmt.Errorf("%s has its own wallpaper setter", desktop)
+	}
+	if err := trySway(imagePath); err == nil {
+		return nil
+	}
+	return tryWbg(imagePath)
+}
+
+// trySway runs swaybg in the background, replacing the previous instance
+func trySway(imagePath string) error {
+	return startBackground("swaybg", "-m", "fill", "-i", imagePath)
+}
+
+// tryWbg runs wbg in the background, replacing the previous instance
+func tryWbg(imagePath string) error {
+	return startBackground("wbg", imagePath)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/adrg/xdg"
+)
+
+// wallpaperTools returns the external programs the built-in wallpaper
+// setters of an OS use, in the order they are tried
+func wallpaperTools(goos string) []string {
+	switch goos {
+	case "linux":
+		return linuxBackendTools()
+	case "darwin":
+		return []string{"osascript"}
+	default:
+		return nil
+	}
+}
+
+// keyFile returns the location of the file holding the API key
+func keyFile() string {
+	return filepath.Join(xdg.ConfigHome, cacheSubdir, "key")
+}
+
+// readKeyFile returns the API key stored in the key file, if any
+func readKeyFile() string {
+	b, err := os.ReadFile(keyFile())
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// desktopEnvironment returns a short description of the running desktop
+func desktopEnvironment() string {
+	if runtime.GOOS != "linux" {
+		return runtime.GOOS
+	}
+	var (
+		desktop = os.Getenv("XDG_CURRENT_DESKTOP")
+		session = os.Getenv("XDG_SESSION_TYPE")
+	)
+	if desktop == "" {
+		desktop = "unknown desktop"
+	}
+	if session == "" {
+		return desktop
+	}
+	return fmt.Sprintf("%s (%s)", desktop, session)
+}
+
+// prompt asks a question on stderr and returns the trimmed answer
+func prompt(r *bufio.Reader, question string) (string, error) {
+	fmt.Fprint(os.Stderr, question)
+	answer, err := r.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return strings.TrimSpace(answer), nil
+}
+
+// runSetupWizard interactively checks the environment, stores an API key
+// and sets a first wallpaper
+func runSetupWizard() error {
+	r := bufio.NewReader(os.Stdin)
+	fmt.Fprintf(os.Stderr, "Desktop: %s\n", desktopEnvironment())
+	var found int
+	for _, tool := range wallpaperTools(runtime.GOOS) {
+		if path, err := exec.LookPath(tool); err == nil {
+			fmt.Fprintf(os.Stderr, "  found %s (%s)\n", tool, path)
+			found++
+		} else {
+			fmt.Fprintf(os.Stderr, "  missing %s\n", tool)
+		}
+	}
+	if found == 0 {
+		fmt.Fprintln(os.Stderr, "No wallpaper backend found, use -set-command with a tool of your choice.")
+	}
+	fmt.Fprintln(os.Stderr, "The shared DEMO_KEY is rate limited, get your own key at https://api.nasa.gov/")
+	key, err := prompt(r, "NASA API key (empty to keep the current one): ")
+	if err != nil {
+		return err
+	}
+	if key != "" {
+		if err := os.MkdirAll(filepath.Dir(keyFile()), 0755); err != nil {
+			return fmt.Errorf("failed to create config dir: %w", err)
+		}
+		if err := os.WriteFile(keyFile(), []byte(key+"\n"), 0600); err != nil {
+			return fmt.Errorf("failed to write key file: %w", err)
+		}
+		fmt.Fprintf(os.Stderr, "Saved key to %s\n", keyFile())
+	}
+	answer, err := prompt(r, "Fetch an APOD and set it as wallpaper now? [Y/n] ")
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(answer) {
+	case "", "y", "yes":
+		if err := fetchAPOD(resolveAPIKey(), true); err != nil {
+			return fmt.Errorf("test run failed: %w", err)
+		}
+	}
+	fmt.Fprint(os.Stderr, `
+Next steps:
+  - change the wallpaper regularly with: apodwall -a -w -daemon 1h
+  - or run "apodwall -a -w" from a systemd user timer or cron
+  - put default flags into `+configFile()+`
+`)
+	return nil
+}